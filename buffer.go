@@ -0,0 +1,326 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Piece table text buffer
+//
+// The original file content is kept in one immutable string and every
+// insertion is appended to a second, append-only buffer. The document is the
+// concatenation of the pieces, each one a span of either source. Line breaks
+// are indexed once per source, so line lookups only walk the piece list and
+// never rescan the text itself.
+
+type pieceSource int
+
+const (
+	sourceOriginal pieceSource = iota
+	sourceAdd
+)
+
+type piece struct {
+	source pieceSource
+	start  int
+	length int
+	breaks int // number of '\n' inside the piece
+}
+
+// changeListener is told about every edit: removed bytes at offset were
+// replaced by inserted bytes.
+type changeListener func(offset, removed, inserted int)
+
+type TextBuffer struct {
+	original       string
+	add            strings.Builder
+	originalBreaks []int // offsets of '\n' in original
+	addBreaks      []int // offsets of '\n' in add
+	pieces         []piece
+	length         int
+	lineBreaks     int
+	listeners      []*changeListener
+}
+
+func NewTextBuffer(text string) *TextBuffer {
+	b := &TextBuffer{
+		original:       text,
+		originalBreaks: indexBreaks(text, 0),
+	}
+	if len(text) > 0 {
+		b.pieces = []piece{{
+			source: sourceOriginal,
+			start:  0,
+			length: len(text),
+			breaks: len(b.originalBreaks),
+		}}
+	}
+	b.length = len(text)
+	b.lineBreaks = len(b.originalBreaks)
+	return b
+}
+
+func indexBreaks(text string, base int) []int {
+	var breaks []int
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			breaks = append(breaks, base+i)
+		}
+	}
+	return breaks
+}
+
+// Len returns the size of the buffer in bytes.
+func (b *TextBuffer) Len() int {
+	return b.length
+}
+
+// LineCount returns the number of lines, which is always at least one.
+func (b *TextBuffer) LineCount() int {
+	return b.lineBreaks + 1
+}
+
+// String returns the full content of the buffer.
+func (b *TextBuffer) String() string {
+	return b.Slice(0, b.length)
+}
+
+// OnChange registers fn to be called after every Insert and Delete, and
+// returns a function that unregisters it.
+func (b *TextBuffer) OnChange(fn changeListener) func() {
+	listener := &fn
+	b.listeners = append(b.listeners, listener)
+	return func() {
+		for i, l := range b.listeners {
+			if l == listener {
+				b.listeners = append(b.listeners[:i:i], b.listeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (b *TextBuffer) notify(offset, removed, inserted int) {
+	for _, fn := range b.listeners {
+		(*fn)(offset, removed, inserted)
+	}
+}
+
+// LineOf returns the line containing the byte offset.
+func (b *TextBuffer) LineOf(offset int) int {
+	line := 0
+	pos := 0
+	for _, p := range b.pieces {
+		if offset < pos+p.length {
+			breaks := b.breaksOf(p)
+			return line + sort.SearchInts(breaks, p.start+offset-pos)
+		}
+		line += p.breaks
+		pos += p.length
+	}
+	return line
+}
+
+// Line returns line n without its trailing newline.
+func (b *TextBuffer) Line(n int) string {
+	if n < 0 || n >= b.LineCount() {
+		return ""
+	}
+	start := b.LineStart(n)
+	end := b.length
+	if n < b.lineBreaks {
+		end = b.LineStart(n+1) - 1
+	}
+	return b.Slice(start, end)
+}
+
+// LineStart returns the byte offset of the first character of line n.
+func (b *TextBuffer) LineStart(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	if n > b.lineBreaks {
+		return b.length
+	}
+	offset := 0
+	for _, p := range b.pieces {
+		if n > p.breaks {
+			n -= p.breaks
+			offset += p.length
+			continue
+		}
+		// The n-th break of this piece ends the previous line.
+		breaks := b.breaksOf(p)
+		return offset + breaks[n-1] - p.start + 1
+	}
+	return b.length
+}
+
+// Slice returns the text between the byte offsets start and end.
+func (b *TextBuffer) Slice(start, end int) string {
+	if start < 0 {
+		start = 0
+	}
+	if end > b.length {
+		end = b.length
+	}
+	if start >= end {
+		return ""
+	}
+
+	var result strings.Builder
+	result.Grow(end - start)
+	offset := 0
+	for _, p := range b.pieces {
+		pieceEnd := offset + p.length
+		if pieceEnd > start && offset < end {
+			from := max(start, offset) - offset
+			to := min(end, pieceEnd) - offset
+			text := b.textOf(p)
+			result.WriteString(text[from:to])
+		}
+		if pieceEnd >= end {
+			break
+		}
+		offset = pieceEnd
+	}
+	return result.String()
+}
+
+// Insert places text at the given byte offset.
+func (b *TextBuffer) Insert(offset int, text string) {
+	if text == "" {
+		return
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > b.length {
+		offset = b.length
+	}
+
+	addStart := b.add.Len()
+	b.add.WriteString(text)
+	b.addBreaks = append(b.addBreaks, indexBreaks(text, addStart)...)
+	inserted := b.newPiece(sourceAdd, addStart, len(text))
+
+	b.length += len(text)
+	b.lineBreaks += inserted.breaks
+	defer b.notify(offset, 0, len(text))
+
+	index, inner := b.findPiece(offset)
+
+	// Typing appends to the end of the previous insertion, so extend that
+	// piece instead of growing the piece list.
+	if inner == 0 && index > 0 {
+		prev := &b.pieces[index-1]
+		if prev.source == sourceAdd && prev.start+prev.length == addStart {
+			prev.length += inserted.length
+			prev.breaks += inserted.breaks
+			return
+		}
+	}
+
+	if inner == 0 {
+		b.pieces = append(b.pieces, piece{})
+		copy(b.pieces[index+1:], b.pieces[index:])
+		b.pieces[index] = inserted
+		return
+	}
+
+	left, right := b.splitPiece(b.pieces[index], inner)
+	b.pieces = append(b.pieces, piece{}, piece{})
+	copy(b.pieces[index+3:], b.pieces[index+1:])
+	b.pieces[index] = left
+	b.pieces[index+1] = inserted
+	b.pieces[index+2] = right
+}
+
+// Delete removes length bytes starting at the given byte offset.
+func (b *TextBuffer) Delete(offset, length int) {
+	if offset < 0 {
+		length += offset
+		offset = 0
+	}
+	if offset+length > b.length {
+		length = b.length - offset
+	}
+	if length <= 0 {
+		return
+	}
+
+	end := offset + length
+	pieces := make([]piece, 0, len(b.pieces)+1)
+	pos := 0
+	for _, p := range b.pieces {
+		pieceEnd := pos + p.length
+		if pieceEnd <= offset || pos >= end {
+			pieces = append(pieces, p)
+			pos = pieceEnd
+			continue
+		}
+		if pos < offset {
+			left, _ := b.splitPiece(p, offset-pos)
+			pieces = append(pieces, left)
+		}
+		if pieceEnd > end {
+			_, right := b.splitPiece(p, end-pos)
+			pieces = append(pieces, right)
+		}
+		pos = pieceEnd
+	}
+
+	removed := strings.Count(b.Slice(offset, end), "\n")
+	b.pieces = pieces
+	b.length -= length
+	b.lineBreaks -= removed
+	b.notify(offset, length, 0)
+}
+
+// findPiece returns the index of the piece containing offset and the offset
+// inside that piece. An offset at a piece boundary belongs to the next piece.
+func (b *TextBuffer) findPiece(offset int) (int, int) {
+	pos := 0
+	for i, p := range b.pieces {
+		if offset < pos+p.length {
+			return i, offset - pos
+		}
+		pos += p.length
+	}
+	return len(b.pieces), 0
+}
+
+func (b *TextBuffer) splitPiece(p piece, at int) (piece, piece) {
+	left := b.newPiece(p.source, p.start, at)
+	right := piece{
+		source: p.source,
+		start:  p.start + at,
+		length: p.length - at,
+		breaks: p.breaks - left.breaks,
+	}
+	return left, right
+}
+
+func (b *TextBuffer) newPiece(source pieceSource, start, length int) piece {
+	p := piece{source: source, start: start, length: length}
+	p.breaks = len(b.breaksOf(p))
+	return p
+}
+
+// breaksOf returns the source offsets of the line breaks inside p.
+func (b *TextBuffer) breaksOf(p piece) []int {
+	breaks := b.originalBreaks
+	if p.source == sourceAdd {
+		breaks = b.addBreaks
+	}
+	lo := sort.SearchInts(breaks, p.start)
+	hi := sort.SearchInts(breaks, p.start+p.length)
+	return breaks[lo:hi]
+}
+
+func (b *TextBuffer) textOf(p piece) string {
+	if p.source == sourceAdd {
+		return b.add.String()[p.start : p.start+p.length]
+	}
+	return b.original[p.start : p.start+p.length]
+}
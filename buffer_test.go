@@ -0,0 +1,170 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// edit is one step applied to a TextBuffer and to a plain string model.
+type edit struct {
+	insert bool
+	offset int
+	length int
+	text   string
+}
+
+func ins(offset int, text string) edit { return edit{insert: true, offset: offset, text: text} }
+func del(offset, length int) edit      { return edit{offset: offset, length: length} }
+
+func (ed edit) apply(s string) string {
+	if ed.insert {
+		return s[:ed.offset] + ed.text + s[ed.offset:]
+	}
+	return s[:ed.offset] + s[ed.offset+ed.length:]
+}
+
+// checkLines compares every line accessor against strings.Split of want.
+func checkLines(t *testing.T, b *TextBuffer, want string) {
+	t.Helper()
+	if got := b.String(); got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+	if b.Len() != len(want) {
+		t.Fatalf("Len() = %d, want %d", b.Len(), len(want))
+	}
+	lines := strings.Split(want, "\n")
+	if b.LineCount() != len(lines) {
+		t.Fatalf("LineCount() = %d, want %d", b.LineCount(), len(lines))
+	}
+	offset := 0
+	for n, line := range lines {
+		if got := b.Line(n); got != line {
+			t.Errorf("Line(%d) = %q, want %q", n, got, line)
+		}
+		if got := b.LineStart(n); got != offset {
+			t.Errorf("LineStart(%d) = %d, want %d", n, got, offset)
+		}
+		for i := 0; i < len(line); i++ {
+			if got := b.LineOf(offset + i); got != n {
+				t.Errorf("LineOf(%d) = %d, want %d", offset+i, got, n)
+			}
+		}
+		offset += len(line) + 1
+	}
+}
+
+func TestTextBufferEdits(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		edits    []edit
+	}{
+		{"empty", "", nil},
+		{"no final newline", "one\ntwo", nil},
+		{"final newline", "one\ntwo\n", nil},
+		{"crlf", "one\r\ntwo\r\n", nil},
+		{"insert into empty", "", []edit{ins(0, "a\nb")}},
+		{"insert at start", "one\ntwo", []edit{ins(0, "zero\n")}},
+		{"insert at end", "one\ntwo", []edit{ins(7, "\nthree")}},
+		{"split piece", "one\ntwo", []edit{ins(2, "X\nY")}},
+		{"typing extends piece", "ab", []edit{ins(1, "x"), ins(2, "\n"), ins(3, "y")}},
+		{"insert at piece boundary", "ab", []edit{ins(1, "x"), ins(1, "\n")}},
+		{"insert crlf", "a\r\nb", []edit{ins(3, "c\r\n")}},
+		{"delete within piece", "one\ntwo\nthree", []edit{del(4, 4)}},
+		{"delete newline joins lines", "one\ntwo", []edit{del(3, 1)}},
+		{"delete across pieces", "one\ntwo", []edit{ins(2, "X\nY"), ins(0, "Z\n"), del(1, 7)}},
+		{"delete everything", "one\ntwo", []edit{ins(3, "\n"), del(0, 8)}},
+		{"delete half of crlf", "a\r\nb", []edit{del(1, 1)}},
+		{"delete final newline", "one\ntwo\n", []edit{del(7, 1)}},
+		{"many small edits", "line1\nline2\nline3", []edit{
+			ins(6, "new\n"), del(0, 2), ins(15, "\n\n"), del(3, 6), ins(0, "top\n"), del(10, 3),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewTextBuffer(tt.original)
+			want := tt.original
+			checkLines(t, b, want)
+			for _, ed := range tt.edits {
+				if ed.insert {
+					b.Insert(ed.offset, ed.text)
+				} else {
+					b.Delete(ed.offset, ed.length)
+				}
+				want = ed.apply(want)
+				checkLines(t, b, want)
+			}
+		})
+	}
+}
+
+func TestTextBufferSlice(t *testing.T) {
+	b := NewTextBuffer("hello\nworld")
+	b.Insert(5, ", there")
+	b.Insert(0, ">> ")
+	want := ">> hello, there\nworld"
+	tests := []struct {
+		start, end int
+	}{
+		{0, len(want)},
+		{2, 9},
+		{3, 8},
+		{8, 15},
+		{14, 18},
+		{0, 0},
+		{-4, 3},
+		{17, 100},
+	}
+	for _, tt := range tests {
+		lo, hi := max(tt.start, 0), min(tt.end, len(want))
+		if got := b.Slice(tt.start, tt.end); got != want[lo:hi] {
+			t.Errorf("Slice(%d, %d) = %q, want %q", tt.start, tt.end, got, want[lo:hi])
+		}
+	}
+}
+
+func TestTextBufferClamps(t *testing.T) {
+	b := NewTextBuffer("abc")
+	b.Insert(-2, "<")
+	b.Insert(100, ">")
+	b.Delete(-1, 2)
+	b.Delete(3, 10)
+	checkLines(t, b, "abc")
+	if b.Line(-1) != "" || b.Line(5) != "" {
+		t.Errorf("Line out of range should be empty")
+	}
+}
+
+func TestTextBufferOnChange(t *testing.T) {
+	b := NewTextBuffer("abc")
+	var got [][3]int
+	b.OnChange(func(offset, removed, inserted int) {
+		got = append(got, [3]int{offset, removed, inserted})
+	})
+	b.Insert(1, "xy")
+	b.Delete(0, 2)
+	b.Insert(0, "")
+	want := [][3]int{{1, 0, 2}, {0, 2, 0}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d notifications, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("notification %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestTextBufferOnChangeStop(t *testing.T) {
+	b := NewTextBuffer("abc")
+	var first, second int
+	stop := b.OnChange(func(offset, removed, inserted int) { first++ })
+	b.OnChange(func(offset, removed, inserted int) { second++ })
+	b.Insert(0, "x")
+	stop()
+	stop()
+	b.Insert(0, "y")
+	if first != 1 || second != 2 {
+		t.Errorf("listeners called %d and %d times, want 1 and 2", first, second)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rivo/tview"
+)
+
+// Buffers
+//
+// Every open file lives in its own Buffer with its text, undo history and
+// unsaved state. A buffer that isn't shown remembers where its cursor was, so
+// switching back returns to the same place.
+
+type Buffer struct {
+	path     string
+	text     *TextBuffer
+	history  *History
+	syntax   *syntaxCache
+	modified bool
+	pos      position
+}
+
+func (e *TextEditor) newBuffer(path, content string) *Buffer {
+	text := NewTextBuffer(content)
+	b := &Buffer{
+		path:    path,
+		text:    text,
+		history: NewHistory(),
+		syntax:  newSyntaxCache(text, e.highlighterFor(path, text, nil)),
+	}
+
+	// Keep the cursors of other windows on this buffer in place
+	text.OnChange(func(offset, removed, inserted int) {
+		b.pos.adjust(offset, removed, inserted)
+		for _, w := range e.windows {
+			if w.buf == b {
+				w.pos.adjust(offset, removed, inserted)
+			}
+		}
+	})
+	return b
+}
+
+// name returns the file name shown for the buffer.
+func (b *Buffer) name() string {
+	if b.path == "" {
+		return "Untitled"
+	}
+	return filepath.Base(b.path)
+}
+
+// isScratch reports whether the buffer is the untouched, unnamed buffer the
+// editor starts with, which opening a file may replace.
+func (b *Buffer) isScratch() bool {
+	return b.path == "" && !b.modified && b.text.Len() == 0
+}
+
+// openBuffer switches to the buffer for path, loading the file if it isn't
+// open yet. A file that doesn't exist yet opens as an empty buffer and is
+// created on the first save.
+func (e *TextEditor) openBuffer(path string) error {
+	if b := e.findBuffer(path); b != nil {
+		e.switchBuffer(b)
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	b := e.newBuffer(path, string(content))
+	if e.buf != nil && e.buf.isScratch() {
+		e.buffers[e.bufferIndex(e.buf)] = b
+		e.replaceBuffer(e.buf, b)
+		e.buf = nil
+	} else {
+		e.buffers = append(e.buffers, b)
+	}
+	e.addRecentFile(path)
+	e.switchBuffer(b)
+	return nil
+}
+
+func (e *TextEditor) findBuffer(path string) *Buffer {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	for _, b := range e.buffers {
+		if b.path == "" {
+			continue
+		}
+		if other, err := filepath.Abs(b.path); err == nil && other == abs {
+			return b
+		}
+	}
+	return nil
+}
+
+func (e *TextEditor) bufferIndex(b *Buffer) int {
+	for i, candidate := range e.buffers {
+		if candidate == b {
+			return i
+		}
+	}
+	return -1
+}
+
+// reloadBuffer reads the current buffer's file again, dropping unsaved
+// changes. The reload itself can be undone.
+func (e *TextEditor) reloadBuffer() error {
+	if e.buf.path == "" {
+		return fmt.Errorf("no file name")
+	}
+	content, err := os.ReadFile(e.buf.path)
+	if err != nil {
+		return err
+	}
+
+	e.buf.history.Close()
+	cursor := cursorPos{e.lineNum, e.colNum}
+	e.replaceText(0, e.buf.text.Len(), string(content))
+	e.buf.history.Close()
+	e.buf.history.MarkSaved()
+	e.buf.modified = false
+	e.setCursor(cursor)
+	e.updateDisplay()
+	return nil
+}
+
+// switchBuffer makes b the buffer shown in the active window.
+func (e *TextEditor) switchBuffer(b *Buffer) {
+	if e.buf != nil {
+		e.buf.pos = e.position()
+		e.buf.history.Close()
+	}
+
+	e.buf = b
+	e.win.buf = b
+	e.setPosition(b.pos)
+	e.showWelcome = false
+	e.updateDisplay()
+}
+
+// replaceBuffer shows b in the other windows that show old.
+func (e *TextEditor) replaceBuffer(old, b *Buffer) {
+	for _, w := range e.windows {
+		if w.buf == old && w != e.win {
+			w.buf = b
+			w.pos = b.pos
+		}
+	}
+}
+
+// cycleBuffer moves delta buffers forward or backward in the buffer list.
+func (e *TextEditor) cycleBuffer(delta int) {
+	if len(e.buffers) < 2 {
+		e.updateStatusBar("No other buffers")
+		return
+	}
+	n := len(e.buffers)
+	next := ((e.bufferIndex(e.buf)+delta)%n + n) % n
+	e.switchBuffer(e.buffers[next])
+}
+
+// closeBuffer closes the current buffer, asking first if it has unsaved
+// changes. Closing the last buffer leaves an empty one.
+func (e *TextEditor) closeBuffer() {
+	e.confirmDiscard(func() {
+		closed := e.buf
+		index := e.bufferIndex(closed)
+		e.buffers = append(e.buffers[:index], e.buffers[index+1:]...)
+		e.buf = nil
+
+		if len(e.buffers) == 0 {
+			e.buffers = []*Buffer{e.newBuffer("", "")}
+		}
+		next := e.buffers[min(index, len(e.buffers)-1)]
+		e.replaceBuffer(closed, next)
+		e.switchBuffer(next)
+	})
+}
+
+// quit stops the editor once every modified buffer has been saved or
+// discarded.
+func (e *TextEditor) quit() {
+	e.confirmBuffers(e.buffers, e.app.Stop)
+}
+
+func (e *TextEditor) confirmBuffers(buffers []*Buffer, action func()) {
+	for i, b := range buffers {
+		if b.modified {
+			rest := buffers[i+1:]
+			e.switchBuffer(b)
+			e.confirmDiscard(func() {
+				e.confirmBuffers(rest, action)
+			})
+			return
+		}
+	}
+	action()
+}
+
+// showBufferList opens a picker with all buffers.
+func (e *TextEditor) showBufferList() {
+	list := tview.NewList()
+	for i, b := range e.buffers {
+		label := b.name()
+		if b.modified {
+			label += " [+]"
+		}
+		path := b.path
+		if path == "" {
+			path = "(not saved yet)"
+		}
+
+		var shortcut rune
+		if i < 9 {
+			shortcut = rune('1' + i)
+		}
+		buffer := b
+		list.AddItem(tview.Escape(label), tview.Escape(path), shortcut, func() {
+			e.closeDialog()
+			e.switchBuffer(buffer)
+		})
+	}
+	list.SetCurrentItem(e.bufferIndex(e.buf))
+	list.SetDoneFunc(func() {
+		e.closeDialog()
+		e.updateDisplay()
+	})
+	list.SetBorder(true)
+	list.SetTitle(fmt.Sprintf("Buffers (%d)", len(e.buffers)))
+
+	e.showingDialog = true
+	e.app.SetRoot(list, true)
+}
+
+func (e *TextEditor) closeDialog() {
+	e.showingDialog = false
+	e.app.SetRoot(e.getMainLayout(), true)
+}
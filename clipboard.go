@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+// System clipboard
+//
+// The + register (and * the same) is the system clipboard: "+yy copies a
+// line to it and "+p pastes from it. A Clipboard moves the text, picked when
+// the config is loaded from what the system has:
+//
+//	wl-copy   wl-copy and wl-paste on Wayland
+//	xclip     xclip on X11
+//	xsel      xsel on X11
+//	osc52     the terminal itself, through an OSC 52 escape sequence, which
+//	          also reaches the clipboard of the machine an SSH session or
+//	          tmux runs in
+//	internal  SWIFT's own clipboard, shared between its buffers only
+//
+// Over SSH the terminal goes first, since the tools would copy on the remote
+// machine. Terminals don't let programs read the clipboard back, so pasting
+// with osc52 gives what SWIFT copied last. The "clipboard" setting picks one
+// by name, and ":health" shows what was found.
+
+type Clipboard interface {
+	Name() string
+	Copy(text string) error
+	Paste() (string, error)
+}
+
+// clipboardNames are the providers the "clipboard" setting accepts.
+var clipboardNames = []string{"auto", "wl-copy", "xclip", "xsel", "osc52", "internal"}
+
+// commandClipboard runs a tool to copy and another to paste.
+type commandClipboard struct {
+	name  string
+	copy  []string
+	paste []string
+}
+
+func (c *commandClipboard) Name() string {
+	return c.name
+}
+
+func (c *commandClipboard) Copy(text string) error {
+	cmd := exec.Command(c.copy[0], c.copy[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	_, err := runTool(cmd)
+	return err
+}
+
+func (c *commandClipboard) Paste() (string, error) {
+	return runTool(exec.Command(c.paste[0], c.paste[1:]...))
+}
+
+// runTool runs a clipboard tool and returns its output, or its first line
+// of errors.
+func runTool(cmd *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); msg != "" {
+			return "", fmt.Errorf("%s: %s", cmd.Args[0], msg)
+		}
+		return "", fmt.Errorf("%s: %v", cmd.Args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// osc52Clipboard copies by asking the terminal to. Inside tmux or screen
+// the sequence is wrapped so they pass it on to the terminal.
+type osc52Clipboard struct {
+	tty  string
+	wrap string // "tmux", "screen" or ""
+	last string
+}
+
+func (c *osc52Clipboard) Name() string {
+	return "osc52"
+}
+
+func (c *osc52Clipboard) Copy(text string) error {
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	switch c.wrap {
+	case "tmux":
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	case "screen":
+		seq = "\x1bP" + seq + "\x1b\\"
+	}
+
+	tty, err := os.OpenFile(c.tty, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	if _, err := tty.WriteString(seq); err != nil {
+		return err
+	}
+	c.last = text
+	return nil
+}
+
+func (c *osc52Clipboard) Paste() (string, error) {
+	if c.last == "" {
+		return "", fmt.Errorf("the terminal can't be read, paste with its own paste key")
+	}
+	return c.last, nil
+}
+
+// internalClipboard keeps the text in the editor.
+type internalClipboard struct {
+	text string
+}
+
+func (c *internalClipboard) Name() string {
+	return "internal"
+}
+
+func (c *internalClipboard) Copy(text string) error {
+	c.text = text
+	return nil
+}
+
+func (c *internalClipboard) Paste() (string, error) {
+	return c.text, nil
+}
+
+// clipboardCheck is what detection found out about a provider.
+type clipboardCheck struct {
+	clipboard Clipboard
+	found     bool
+	detail    string
+}
+
+// detectClipboards checks which providers this system has, in the order
+// they are preferred.
+func detectClipboards() []clipboardCheck {
+	tools := func(name, env string, copy, paste []string) clipboardCheck {
+		c := clipboardCheck{clipboard: &commandClipboard{name, copy, paste}}
+		switch {
+		case os.Getenv(env) == "":
+			c.detail = env + " is not set"
+		default:
+			var paths []string
+			for _, tool := range []string{copy[0], paste[0]} {
+				path, err := exec.LookPath(tool)
+				if err != nil {
+					c.detail = tool + " not found"
+					return c
+				}
+				if !slices.Contains(paths, path) {
+					paths = append(paths, path)
+				}
+			}
+			c.detail = strings.Join(paths, ", ")
+			c.found = true
+		}
+		return c
+	}
+
+	checks := []clipboardCheck{
+		tools("wl-copy", "WAYLAND_DISPLAY", []string{"wl-copy"}, []string{"wl-paste", "--no-newline"}),
+		tools("xclip", "DISPLAY", []string{"xclip", "-selection", "clipboard", "-in"}, []string{"xclip", "-selection", "clipboard", "-out"}),
+		tools("xsel", "DISPLAY", []string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}),
+	}
+
+	osc := &osc52Clipboard{tty: "/dev/tty"}
+	terminal := clipboardCheck{clipboard: osc}
+	tty, err := os.OpenFile(osc.tty, os.O_WRONLY, 0)
+	switch {
+	case err != nil:
+		terminal.detail = "no terminal: " + err.Error()
+	case os.Getenv("TERM") == "dumb" || os.Getenv("TERM") == "linux":
+		tty.Close()
+		terminal.detail = fmt.Sprintf("TERM=%s can't set the clipboard", os.Getenv("TERM"))
+	default:
+		tty.Close()
+		terminal.found = true
+		terminal.detail = "writing to " + osc.tty
+		if os.Getenv("TMUX") != "" {
+			osc.wrap = "tmux"
+			terminal.detail += " through tmux, which needs set-clipboard or allow-passthrough on"
+		} else if strings.HasPrefix(os.Getenv("TERM"), "screen") {
+			osc.wrap = "screen"
+			terminal.detail += " through screen"
+		}
+	}
+
+	// Over SSH the tools would reach the remote machine's clipboard
+	if os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != "" {
+		checks = append([]clipboardCheck{terminal}, checks...)
+	} else {
+		checks = append(checks, terminal)
+	}
+	return append(checks, clipboardCheck{clipboard: &internalClipboard{}, found: true, detail: "always there"})
+}
+
+// chooseClipboard returns the provider the setting names if it was found,
+// and otherwise the first one found. The internal clipboard keeps its text
+// when it is chosen again.
+func (e *TextEditor) chooseClipboard(setting string, checks []clipboardCheck) Clipboard {
+	var chosen Clipboard
+	for _, c := range checks {
+		if c.found && (c.clipboard.Name() == setting || chosen == nil) {
+			chosen = c.clipboard
+		}
+	}
+	if e.clipboard != nil && e.clipboard.Name() == chosen.Name() {
+		if _, ok := chosen.(*internalClipboard); ok {
+			return e.clipboard
+		}
+	}
+	return chosen
+}
+
+// copyToClipboard puts a register's text on the system clipboard.
+func (e *TextEditor) copyToClipboard(r register) {
+	if err := e.clipboard.Copy(r.text); err != nil {
+		e.updateStatusBar(fmt.Sprintf("Error: clipboard: %v", err))
+	}
+}
+
+// clipboardRegister returns the clipboard's text as a register. Text SWIFT
+// copied keeps its kind, and other text ending in a line break pastes as
+// lines.
+func (e *TextEditor) clipboardRegister() (register, error) {
+	text, err := e.clipboard.Paste()
+	if err != nil {
+		return register{}, fmt.Errorf("clipboard: %v", err)
+	}
+	if copied := e.registers['+']; copied.text == text {
+		return copied, nil
+	}
+	return register{text: text, linewise: strings.HasSuffix(text, "\n")}, nil
+}
+
+// showHealth reports what the editor found out about the system: the config
+// file, the syntax files and the clipboard providers.
+func (e *TextEditor) showHealth() {
+	var text strings.Builder
+	line := func(format string, args ...any) {
+		text.WriteString(tview.Escape(fmt.Sprintf(format, args...)) + "\n")
+	}
+	status := func(ok bool) string {
+		if ok {
+			return "[green]OK[-]  "
+		}
+		return "[red]--[-]  "
+	}
+
+	text.WriteString("[yellow]Config[-]\n")
+	path := configPath()
+	if _, err := loadConfig(path, e.languages); err != nil {
+		text.WriteString(status(false))
+		line("%v", err)
+	} else if path == "" {
+		text.WriteString(status(false))
+		line("No config directory, using the defaults")
+	} else if _, err := os.Stat(path); err != nil {
+		text.WriteString(status(true))
+		line("%s not found, using the defaults", path)
+	} else {
+		text.WriteString(status(true))
+		line("%s", path)
+	}
+
+	text.WriteString("\n[yellow]Syntax[-]\n")
+	syntaxDir := ""
+	if dir := configDir(); dir != "" {
+		syntaxDir = filepath.Join(dir, "syntax")
+	}
+	_, syntaxErrors := loadLanguages(syntaxDir)
+	text.WriteString(status(len(syntaxErrors) == 0))
+	if syntaxDir == "" {
+		line("%d languages", len(e.languages))
+	} else {
+		line("%d languages, user files in %s", len(e.languages), syntaxDir)
+	}
+	for _, err := range syntaxErrors {
+		text.WriteString(status(false))
+		line("%v", err)
+	}
+
+	text.WriteString("\n[yellow]Clipboard[-]\n")
+	checks := detectClipboards()
+	setting := e.config.clipboard()
+	line("Using %s (setting %q)", e.clipboard.Name(), setting)
+	if setting != "auto" && setting != e.clipboard.Name() {
+		line("%s wasn't found, so the first one found is used", setting)
+	}
+	for _, c := range checks {
+		text.WriteString(status(c.found))
+		line("%-9s %s", c.clipboard.Name(), c.detail)
+	}
+	e.showText(" Health - Esc to close ", text.String())
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Command line
+//
+// ':' opens the command line. A command is an optional line range, a name, an
+// optional '!' and an argument:
+//
+//	:w other.txt    :e! main.go    :10,20d    :%s/a/b/g    :120
+//
+// Names can be shortened to any prefix at least as long as their short form,
+// so "w", "wr" and "write" are the same command. Tab completes command names
+// and file paths.
+
+// exCommand is a parsed command line.
+type exCommand struct {
+	start, end int // Line range, zero based and both included
+	hasRange   bool
+	name       string
+	bang       bool
+	arg        string
+	key        string // The key that ran the command, if any
+	count      int    // The count typed before the key, 0 if none
+}
+
+// times returns how often a command that repeats with a count runs.
+func (c *exCommand) times() int {
+	return max(c.count, 1)
+}
+
+// startCommandLine opens the command line.
+func (e *TextEditor) startCommandLine() {
+	e.showPrompt(":", nil, func(line string, accepted bool) {
+		if accepted {
+			e.runCommandLine(line)
+		}
+	})
+
+	var completion *completer
+	e.prompt.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyTab, tcell.KeyBacktab:
+			text := e.prompt.GetText()
+			if completion == nil || completion.current() != text {
+				completion = e.complete(text)
+			}
+			if completion != nil {
+				e.prompt.SetText(completion.next(event.Key() == tcell.KeyBacktab))
+			}
+			return nil
+		}
+		return event
+	})
+}
+
+// runCommandLine parses and runs a command line, showing any error in the
+// status bar.
+func (e *TextEditor) runCommandLine(line string) {
+	cmd, def, err := e.parseCommand(line)
+	if err == nil && def != nil {
+		err = def.run(e, cmd)
+	}
+	if err != nil {
+		e.updateStatusBar(fmt.Sprintf("Error: %v", err))
+	}
+}
+
+// parseCommand splits a command line into its parts and looks up the
+// command. A line with only a range has no command and jumps to the range's
+// last line.
+func (e *TextEditor) parseCommand(line string) (*exCommand, *Command, error) {
+	line = strings.TrimLeft(line, ": \t")
+	cmd := &exCommand{start: e.lineNum, end: e.lineNum}
+
+	rest, err := e.parseRange(line, cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	rest = strings.TrimLeft(rest, " \t")
+
+	cmd.name, cmd.bang, rest = splitName(rest)
+	cmd.arg = strings.TrimSpace(rest)
+
+	if cmd.name == "" {
+		if cmd.bang || cmd.arg != "" {
+			return nil, nil, fmt.Errorf("not an editor command: %s", line)
+		}
+		if cmd.hasRange && !e.showWelcome {
+			e.buf.history.Close()
+			e.setCursor(cursorPos{cmd.end, 0})
+			e.updateDisplay()
+		}
+		return cmd, nil, nil
+	}
+
+	def := findCommand(cmd.name)
+	if def == nil {
+		return nil, nil, fmt.Errorf("not an editor command: %s", line)
+	}
+	if err := cmd.check(def); err != nil {
+		return nil, nil, err
+	}
+	if def.arg == argText {
+		// Keep the spacing of patterns and replacements
+		cmd.arg = rest
+	}
+	return cmd, def, nil
+}
+
+// splitName splits a command without its range into the name, whether a '!'
+// follows it, and the rest. The name is a run of letters, so "s/a/b/" is the
+// command "s" with the argument "/a/b/".
+func splitName(s string) (string, bool, string) {
+	n := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsLetter(r) })
+	if n < 0 {
+		n = len(s)
+	}
+	name, rest := s[:n], s[n:]
+	if strings.HasPrefix(rest, "!") {
+		return name, true, rest[1:]
+	}
+	return name, false, rest
+}
+
+// check reports a range, '!' or argument that def doesn't take.
+func (c *exCommand) check(def *Command) error {
+	switch {
+	case c.hasRange && !def.rng:
+		return fmt.Errorf("no range allowed")
+	case c.bang && !def.bang:
+		return fmt.Errorf("no ! allowed")
+	case c.arg != "" && def.arg == argNone:
+		return fmt.Errorf("trailing characters: %s", c.arg)
+	}
+	return nil
+}
+
+// parseRange reads a line range like "%", "5", ".,$" or ".,+3" from the start
+// of line into cmd and returns the rest of the line.
+func (e *TextEditor) parseRange(line string, cmd *exCommand) (string, error) {
+	if strings.HasPrefix(line, "%") {
+		cmd.start, cmd.end, cmd.hasRange = 0, e.lastLine(), true
+		return line[1:], nil
+	}
+
+	start, rest, ok, err := e.parseAddress(line)
+	if err != nil || !ok {
+		return rest, err
+	}
+	end := start
+	if strings.HasPrefix(rest, ",") {
+		if end, rest, ok, err = e.parseAddress(rest[1:]); err != nil {
+			return rest, err
+		} else if !ok {
+			return rest, fmt.Errorf("missing line after ','")
+		}
+	}
+	if end < start {
+		start, end = end, start
+	}
+	cmd.start, cmd.end, cmd.hasRange = start, end, true
+	return rest, nil
+}
+
+// parseAddress reads one line address: a line number, '.' for the current
+// line or '$' for the last, followed by any number of +N and -N offsets.
+func (e *TextEditor) parseAddress(s string) (int, string, bool, error) {
+	line, found := e.lineNum, false
+	switch {
+	case strings.HasPrefix(s, "."):
+		s, found = s[1:], true
+	case strings.HasPrefix(s, "$"):
+		line, s, found = e.lastLine(), s[1:], true
+	default:
+		if digits := leadingDigits(s); digits != "" {
+			// Line numbers past the end mean the last line
+			n, _ := strconv.Atoi(digits)
+			line, s, found = min(max(n-1, 0), e.lastLine()), s[len(digits):], true
+		}
+	}
+
+	for len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		sign := 1
+		if s[0] == '-' {
+			sign = -1
+		}
+		digits := leadingDigits(s[1:])
+		offset := 1
+		if digits != "" {
+			offset, _ = strconv.Atoi(digits)
+		}
+		line += sign * offset
+		s, found = s[1+len(digits):], true
+	}
+
+	if found && (line < 0 || line > e.lastLine()) {
+		return 0, s, false, fmt.Errorf("invalid range")
+	}
+	return line, s, found, nil
+}
+
+func leadingDigits(s string) string {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return s[:n]
+}
+
+func (e *TextEditor) lastLine() int {
+	return e.buf.text.LineCount() - 1
+}
+
+// expandPath replaces a leading ~ with the home directory.
+func expandPath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + path[1:]
+		}
+	}
+	return path
+}
+
+// completer cycles through the completions of a command line.
+type completer struct {
+	prefix      string
+	candidates  []string
+	index       int
+	initialized bool
+}
+
+func (c *completer) current() string {
+	if !c.initialized {
+		return ""
+	}
+	return c.prefix + c.candidates[c.index]
+}
+
+func (c *completer) next(backward bool) string {
+	switch {
+	case !c.initialized:
+		c.initialized = true
+		if backward {
+			c.index = len(c.candidates) - 1
+		}
+	case backward:
+		c.index = (c.index - 1 + len(c.candidates)) % len(c.candidates)
+	default:
+		c.index = (c.index + 1) % len(c.candidates)
+	}
+	return c.current()
+}
+
+// complete returns the completions for a command line: command names while
+// the name is typed, and file paths in the argument of commands taking one.
+func (e *TextEditor) complete(line string) *completer {
+	var cmd exCommand
+	rest, err := e.parseRange(line, &cmd)
+	if err != nil {
+		return nil
+	}
+	head := line[:len(line)-len(rest)]
+
+	name, arg, hasArg := strings.Cut(rest, " ")
+	if !hasArg {
+		var names []string
+		for _, def := range commands {
+			if strings.HasPrefix(def.name, name) {
+				names = append(names, def.name)
+			}
+		}
+		return newCompleter(head, names)
+	}
+
+	def := findCommand(strings.TrimSuffix(name, "!"))
+	if def == nil || def.arg != argFile {
+		return nil
+	}
+	head += name + " "
+	arg = strings.TrimLeft(arg, " ")
+	dir, base := filepath.Split(arg)
+	entries, err := os.ReadDir(expandPath(dir + "."))
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if !strings.HasPrefix(entryName, base) || (strings.HasPrefix(entryName, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if entry.IsDir() {
+			entryName += string(filepath.Separator)
+		}
+		paths = append(paths, dir+entryName)
+	}
+	return newCompleter(head, paths)
+}
+
+func newCompleter(prefix string, candidates []string) *completer {
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Strings(candidates)
+	return &completer{prefix: prefix, candidates: candidates}
+}
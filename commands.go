@@ -0,0 +1,556 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+// Command registry
+//
+// Every command the editor has is listed here once, with its names, argument,
+// description and default keys. The command line, its completion, the key
+// bindings and the help screen are all built from this list.
+
+type argKind int
+
+const (
+	argNone argKind = iota
+	argText
+	argFile
+)
+
+type Command struct {
+	name       string
+	short      string   // Shortest accepted abbreviation, the full name if empty
+	aliases    []string // Other names, like "ls" for "buffers"
+	group      string   // Help section
+	rng        bool     // Takes a line range
+	bang       bool     // Accepts '!'
+	arg        argKind
+	argName    string // Argument as shown in the help, like "[file]"
+	desc       string
+	keys       []string // Default keys in View Mode
+	editKeys   []string // Default keys in Edit Mode
+	visualKeys []string // Default keys in Visual Mode, besides those of View Mode
+	opKeys     []string // Default keys after an operator, like the i of "diw"
+	run        func(e *TextEditor, c *exCommand) error
+}
+
+// Help sections in the order they are shown
+var commandGroups = []string{"Modes", "Movement", "Editing", "Search", "Files", "Buffers", "Windows", "Help"}
+
+var commands []*Command
+
+func init() {
+	commands = []*Command{
+		// Modes
+		{name: "insert", group: "Modes", desc: "Switch to Edit Mode", keys: []string{"i"},
+			run: func(e *TextEditor, c *exCommand) error { e.enterEditMode(); return nil }},
+		{name: "view", group: "Modes", desc: "Go back to View Mode", editKeys: []string{"Esc"}, visualKeys: []string{"Esc"},
+			run: func(e *TextEditor, c *exCommand) error { e.enterViewMode(); return nil }},
+		{name: "visual", group: "Modes", desc: "Select characters", keys: []string{"v"},
+			run: func(e *TextEditor, c *exCommand) error { e.startVisual(visualChar); return nil }},
+		{name: "visualline", group: "Modes", desc: "Select lines", keys: []string{"V"},
+			run: func(e *TextEditor, c *exCommand) error { e.startVisual(visualLine); return nil }},
+		{name: "visualblock", group: "Modes", desc: "Select a block", keys: []string{"Ctrl+V"},
+			run: func(e *TextEditor, c *exCommand) error { e.startVisual(visualBlock); return nil }},
+		{name: "commandline", group: "Modes", desc: "Type a command", keys: []string{":"},
+			run: func(e *TextEditor, c *exCommand) error {
+				if e.mode == VisualMode {
+					e.startVisualCommandLine("")
+					return nil
+				}
+				e.startCommandLine()
+				return nil
+			}},
+
+		// Movement
+		{name: "up", group: "Movement", desc: "Move up a line", keys: []string{"Up", "k"}, editKeys: []string{"Up"},
+			run: move(moved((*TextEditor).moveUp, linewise))},
+		{name: "down", group: "Movement", desc: "Move down a line", keys: []string{"Down", "j"}, editKeys: []string{"Down"},
+			run: move(moved((*TextEditor).moveDown, linewise))},
+		{name: "left", group: "Movement", desc: "Move left a character", keys: []string{"Left"}, editKeys: []string{"Left"},
+			run: move(moved((*TextEditor).moveLeft, exclusive))},
+		{name: "right", group: "Movement", desc: "Move right a character", keys: []string{"Right"}, editKeys: []string{"Right"},
+			run: move(moved((*TextEditor).moveRight, exclusive))},
+		{name: "wordnext", group: "Movement", desc: "Go to the next word", keys: []string{"w"},
+			run: move(wordForward)},
+		{name: "wordprev", group: "Movement", desc: "Go to the start of the word", keys: []string{"b"},
+			run: move(wordBackward)},
+		{name: "wordend", group: "Movement", desc: "Go to the end of the word", keys: []string{"e"},
+			run: move(wordForwardEnd)},
+		{name: "linestart", group: "Movement", desc: "Go to the start of the line", keys: []string{"Home", "0"}, editKeys: []string{"Home"},
+			run: move(moved((*TextEditor).moveToLineStart, exclusive))},
+		{name: "firstchar", group: "Movement", desc: "Go to the first non-blank", keys: []string{"^"},
+			run: move(lineFirstChar)},
+		{name: "lineend", group: "Movement", desc: "Go to the end of the line", keys: []string{"End", "$"}, editKeys: []string{"End"},
+			run: move(lineEnd)},
+		{name: "top", group: "Movement", desc: "Go to the first line, or line N", keys: []string{"g g"},
+			run: move(fileTop)},
+		{name: "bottom", group: "Movement", desc: "Go to the last line, or line N", keys: []string{"G"},
+			run: move(fileBottom)},
+		{name: "paranext", group: "Movement", desc: "Go to the next blank line", keys: []string{"}"},
+			run: move(paragraphForward)},
+		{name: "paraprev", group: "Movement", desc: "Go to the previous blank line", keys: []string{"{"},
+			run: move(paragraphBackward)},
+		{name: "swapends", group: "Movement", desc: "Go to the other end of the selection", visualKeys: []string{"o"},
+			run: func(e *TextEditor, c *exCommand) error { e.swapEnds(); return nil }},
+		{name: "find", group: "Movement", desc: "Go to the next character typed", keys: []string{"f"},
+			run: func(e *TextEditor, c *exCommand) error { e.awaitChar(c, true, false); return nil }},
+		{name: "till", group: "Movement", desc: "Go to before the next character", keys: []string{"t"},
+			run: func(e *TextEditor, c *exCommand) error { e.awaitChar(c, true, true); return nil }},
+		{name: "findback", group: "Movement", desc: "Go to the previous character", keys: []string{"F"},
+			run: func(e *TextEditor, c *exCommand) error { e.awaitChar(c, false, false); return nil }},
+		{name: "tillback", group: "Movement", desc: "Go to after the previous character", keys: []string{"T"},
+			run: func(e *TextEditor, c *exCommand) error { e.awaitChar(c, false, true); return nil }},
+
+		// Editing
+		{name: "newline", group: "Editing", desc: "Start a new line", editKeys: []string{"Enter"},
+			run: func(e *TextEditor, c *exCommand) error { e.insertNewline(); return nil }},
+		{name: "backspace", group: "Editing", desc: "Delete the character before", editKeys: []string{"Backspace"},
+			run: func(e *TextEditor, c *exCommand) error { e.backspace(); return nil }},
+		{name: "deletechar", group: "Editing", desc: "Delete the character under", editKeys: []string{"Delete"},
+			run: func(e *TextEditor, c *exCommand) error { e.delete(); return nil }},
+		{name: "indent", group: "Editing", desc: "Insert tabWidth spaces", editKeys: []string{"Tab"},
+			run: func(e *TextEditor, c *exCommand) error { e.insertTab(); return nil }},
+		{name: "undo", short: "u", group: "Editing", desc: "Undo the last change", keys: []string{"u"},
+			run: repeat((*TextEditor).undo)},
+		{name: "redo", short: "red", group: "Editing", desc: "Redo the last undone change", keys: []string{"Ctrl+R"},
+			run: repeat((*TextEditor).redo)},
+		{name: "delete", short: "d", group: "Editing", rng: true, desc: "Delete lines",
+			run: func(e *TextEditor, c *exCommand) error { e.deleteLines(c.start, c.end); return nil }},
+		{name: "deleteto", group: "Editing", desc: "Delete to a motion, or lines", keys: []string{"d"}, visualKeys: []string{"x"},
+			run: func(e *TextEditor, c *exCommand) error { e.startOperator("delete", c); return nil }},
+		{name: "changeto", group: "Editing", desc: "Change to a motion, or lines", keys: []string{"c"},
+			run: func(e *TextEditor, c *exCommand) error { e.startOperator("change", c); return nil }},
+		{name: "yankto", group: "Editing", desc: "Copy to a motion, or lines", keys: []string{"y"},
+			run: func(e *TextEditor, c *exCommand) error { e.startOperator("yank", c); return nil }},
+		{name: "register", group: "Editing", desc: "Use a register for the next command", keys: []string{"\""},
+			run: func(e *TextEditor, c *exCommand) error { e.awaitRegister(c); return nil }},
+		{name: "paste", group: "Editing", desc: "Paste after the cursor", keys: []string{"p"},
+			run: func(e *TextEditor, c *exCommand) error { return e.paste(c, false) }},
+		{name: "pastebefore", group: "Editing", desc: "Paste before the cursor", keys: []string{"P"},
+			run: func(e *TextEditor, c *exCommand) error { return e.paste(c, true) }},
+		{name: "insertregister", group: "Editing", desc: "Type the text of a register", editKeys: []string{"Ctrl+R"},
+			run: func(e *TextEditor, c *exCommand) error { e.awaitInsertRegister(c); return nil }},
+		{name: "registers", short: "reg", group: "Editing", desc: "List the registers",
+			run: func(e *TextEditor, c *exCommand) error { e.showRegisters(); return nil }},
+		{name: "inner", group: "Editing", desc: "Inside an object, like iw or i(", opKeys: []string{"i"},
+			run: func(e *TextEditor, c *exCommand) error { return e.awaitObject(c, false) }},
+		{name: "around", group: "Editing", desc: "Around an object, like aw or a\"", opKeys: []string{"a"},
+			run: func(e *TextEditor, c *exCommand) error { return e.awaitObject(c, true) }},
+		{name: "shiftright", group: "Editing", rng: true, desc: "Indent lines", keys: []string{"> >"}, visualKeys: []string{">"},
+			run: func(e *TextEditor, c *exCommand) error { e.shiftBy(c, true); return nil }},
+		{name: "shiftleft", group: "Editing", rng: true, desc: "Take indentation away", keys: []string{"< <"}, visualKeys: []string{"<"},
+			run: func(e *TextEditor, c *exCommand) error { e.shiftBy(c, false); return nil }},
+		{name: "togglecase", group: "Editing", rng: true, desc: "Swap upper and lower case", visualKeys: []string{"~"},
+			run: func(e *TextEditor, c *exCommand) error { e.changeCase(c, toggleCase); return nil }},
+		{name: "lowercase", group: "Editing", rng: true, desc: "Make lower case", visualKeys: []string{"u"},
+			run: func(e *TextEditor, c *exCommand) error { e.changeCase(c, strings.ToLower); return nil }},
+		{name: "uppercase", group: "Editing", rng: true, desc: "Make upper case", visualKeys: []string{"U"},
+			run: func(e *TextEditor, c *exCommand) error { e.changeCase(c, strings.ToUpper); return nil }},
+		{name: "filter", group: "Editing", rng: true, arg: argText, argName: "command", desc: "Pipe lines through a command",
+			visualKeys: []string{"!"}, run: (*TextEditor).cmdFilter},
+
+		// Search
+		{name: "search", group: "Search", desc: "Search forward", keys: []string{"/"},
+			run: func(e *TextEditor, c *exCommand) error { e.startSearch(false); return nil }},
+		{name: "searchback", group: "Search", desc: "Search backward", keys: []string{"?"},
+			run: func(e *TextEditor, c *exCommand) error { e.startSearch(true); return nil }},
+		{name: "next", group: "Search", desc: "Go to the next match", keys: []string{"n"},
+			run: func(e *TextEditor, c *exCommand) error {
+				for i := 0; i < c.times(); i++ {
+					e.searchNext(false)
+				}
+				return nil
+			}},
+		{name: "previous", group: "Search", desc: "Go to the previous match", keys: []string{"N"},
+			run: func(e *TextEditor, c *exCommand) error {
+				for i := 0; i < c.times(); i++ {
+					e.searchNext(true)
+				}
+				return nil
+			}},
+		{name: "nohlsearch", short: "noh", group: "Search", desc: "Hide the match highlighting", keys: []string{"Esc"},
+			run: func(e *TextEditor, c *exCommand) error { e.showMatches = false; e.updateDisplay(); return nil }},
+		{name: "substitute", short: "s", group: "Search", rng: true, arg: argText, argName: "/old/new/[gic]",
+			desc: "Replace matches",
+			run:  func(e *TextEditor, c *exCommand) error { return e.substitute(c) }},
+
+		// Files
+		{name: "write", short: "w", group: "Files", bang: true, arg: argFile, argName: "[file]", desc: "Save, or save as file (! overwrites it)",
+			run: (*TextEditor).cmdWrite},
+		{name: "wq", group: "Files", bang: true, arg: argFile, argName: "[file]", desc: "Save and quit",
+			run: (*TextEditor).cmdWriteQuit},
+		{name: "xit", short: "x", group: "Files", bang: true, arg: argFile, argName: "[file]", desc: "Save and quit",
+			run: (*TextEditor).cmdWriteQuit},
+		{name: "quit", short: "q", group: "Files", bang: true, desc: "Quit", keys: []string{"Ctrl+Q"},
+			run: (*TextEditor).cmdQuit},
+		{name: "edit", short: "e", group: "Files", bang: true, arg: argFile, argName: "[file]", desc: "Open file, or reload with !",
+			run: (*TextEditor).cmdEdit},
+		{name: "open", short: "o", group: "Files", arg: argFile, argName: "[file]", desc: "Open file, asking for the path",
+			run: (*TextEditor).cmdOpen},
+		{name: "new", short: "n", group: "Files", desc: "Start a new file",
+			run: func(e *TextEditor, c *exCommand) error { e.newFile(); return nil }},
+
+		{name: "reloadconfig", group: "Files", desc: "Read the config file again",
+			run: func(e *TextEditor, c *exCommand) error { return e.reloadConfig() }},
+
+		// Buffers
+		{name: "bnext", short: "bn", group: "Buffers", desc: "Go to the next buffer", keys: []string{"Tab"},
+			run: func(e *TextEditor, c *exCommand) error { e.cycleBuffer(c.times()); return nil }},
+		{name: "bprevious", short: "bp", group: "Buffers", desc: "Go to the previous buffer", keys: []string{"Shift+Tab"},
+			run: func(e *TextEditor, c *exCommand) error { e.cycleBuffer(-c.times()); return nil }},
+		{name: "buffers", aliases: []string{"ls"}, group: "Buffers", desc: "List open buffers",
+			run: func(e *TextEditor, c *exCommand) error { e.showBufferList(); return nil }},
+		{name: "bdelete", short: "bd", group: "Buffers", bang: true, desc: "Close the buffer", keys: []string{"Alt+w"},
+			run: (*TextEditor).cmdBufferDelete},
+		{name: "tab", group: "Buffers", arg: argText, argName: "N", desc: "Go to tab N",
+			keys: []string{"Alt+1", "Alt+2", "Alt+3", "Alt+4", "Alt+5", "Alt+6", "Alt+7", "Alt+8", "Alt+9"},
+			run:  (*TextEditor).cmdTab},
+		{name: "tableft", group: "Buffers", desc: "Move the tab left", keys: []string{"Alt+<"},
+			run: func(e *TextEditor, c *exCommand) error { e.moveTab(-1); return nil }},
+		{name: "tabright", group: "Buffers", desc: "Move the tab right", keys: []string{"Alt+>"},
+			run: func(e *TextEditor, c *exCommand) error { e.moveTab(1); return nil }},
+
+		// Windows
+		{name: "split", short: "sp", group: "Windows", arg: argFile, argName: "[file]", desc: "Split the window below",
+			run: (*TextEditor).cmdSplit},
+		{name: "vsplit", short: "vs", group: "Windows", arg: argFile, argName: "[file]", desc: "Split the window beside",
+			run: (*TextEditor).cmdSplit},
+		{name: "close", short: "clo", group: "Windows", desc: "Close the window",
+			run: func(e *TextEditor, c *exCommand) error { e.closeWindow(); return nil }},
+		{name: "only", short: "on", group: "Windows", desc: "Close every other window",
+			run: func(e *TextEditor, c *exCommand) error { e.onlyWindow(); return nil }},
+		{name: "wnext", group: "Windows", desc: "Go to the next window", keys: []string{"Ctrl+W"},
+			run: func(e *TextEditor, c *exCommand) error { e.cycleWindow(); return nil }},
+		{name: "wup", group: "Windows", desc: "Go to the window above", keys: []string{"Alt+Up"},
+			run: func(e *TextEditor, c *exCommand) error { e.moveFocus(0, -1); return nil }},
+		{name: "wdown", group: "Windows", desc: "Go to the window below", keys: []string{"Alt+Down"},
+			run: func(e *TextEditor, c *exCommand) error { e.moveFocus(0, 1); return nil }},
+		{name: "wleft", group: "Windows", desc: "Go to the window on the left", keys: []string{"Alt+Left"},
+			run: func(e *TextEditor, c *exCommand) error { e.moveFocus(-1, 0); return nil }},
+		{name: "wright", group: "Windows", desc: "Go to the window on the right", keys: []string{"Alt+Right"},
+			run: func(e *TextEditor, c *exCommand) error { e.moveFocus(1, 0); return nil }},
+		{name: "taller", group: "Windows", desc: "Make the window taller", keys: []string{"Ctrl+Up"},
+			run: func(e *TextEditor, c *exCommand) error { e.resizeWindow(false, 1); return nil }},
+		{name: "shorter", group: "Windows", desc: "Make the window shorter", keys: []string{"Ctrl+Down"},
+			run: func(e *TextEditor, c *exCommand) error { e.resizeWindow(false, -1); return nil }},
+		{name: "wider", group: "Windows", desc: "Make the window wider", keys: []string{"Ctrl+Right"},
+			run: func(e *TextEditor, c *exCommand) error { e.resizeWindow(true, 1); return nil }},
+		{name: "narrower", group: "Windows", desc: "Make the window narrower", keys: []string{"Ctrl+Left"},
+			run: func(e *TextEditor, c *exCommand) error { e.resizeWindow(true, -1); return nil }},
+
+		// Help
+		{name: "health", aliases: []string{"checkhealth"}, group: "Help", desc: "Check the config, syntax files and clipboard",
+			run: func(e *TextEditor, c *exCommand) error { e.showHealth(); return nil }},
+		{name: "bindings", group: "Help", desc: "List the active key bindings",
+			run: func(e *TextEditor, c *exCommand) error { e.showBindings(); return nil }},
+		{name: "palette", group: "Help", desc: "Find a command, buffer or file", keys: []string{"Ctrl+P"},
+			run: func(e *TextEditor, c *exCommand) error { e.showPalette(); return nil }},
+		{name: "help", short: "h", group: "Help", desc: "Show this help", keys: []string{"h"},
+			run: func(e *TextEditor, c *exCommand) error { e.openHelp(); return nil }},
+	}
+}
+
+// repeat makes a command of an editor action, run as often as the count says.
+func repeat(action func(e *TextEditor)) func(e *TextEditor, c *exCommand) error {
+	return func(e *TextEditor, c *exCommand) error {
+		for i := 0; i < c.times(); i++ {
+			action(e)
+		}
+		return nil
+	}
+}
+
+// move makes a command of a motion.
+func move(m motion) func(e *TextEditor, c *exCommand) error {
+	return func(e *TextEditor, c *exCommand) error {
+		e.moveBy(m, c)
+		return nil
+	}
+}
+
+// findCommand returns the command that name is an abbreviation or alias of.
+func findCommand(name string) *Command {
+	for _, def := range commands {
+		short := def.short
+		if short == "" {
+			short = def.name
+		}
+		if strings.HasPrefix(def.name, name) && len(name) >= len(short) {
+			return def
+		}
+		for _, alias := range def.aliases {
+			if name == alias {
+				return def
+			}
+		}
+	}
+	return nil
+}
+
+// getHelpText lists every command with its keys, built from the registry.
+func (e *TextEditor) getHelpText() string {
+	var help strings.Builder
+	help.WriteString("[yellow]SWIFT Help & Commands[-]\n\n")
+	help.WriteString("Press a key in View Mode, or ':' and a command then Enter. Commands can be\n")
+	help.WriteString("shortened to the part in brackets, and Tab completes them. A line number\n")
+	help.WriteString("or range goes in front: ':120' jumps to line 120, ':10,20d' deletes lines,\n")
+	help.WriteString("'%' is the whole file, '.' the current line and '$' the last. A '!' drops\n")
+	help.WriteString("unsaved changes, or lets ':w' write over another file. Substitute flags\n")
+	help.WriteString("are g (every match in a line), i (ignore case) and c (confirm each);\n")
+	help.WriteString("Ctrl+R in the search prompt switches to regex. Keys like 'g g' are\n")
+	help.WriteString("pressed one after the other.\n")
+	help.WriteString(tview.Escape(wrapText(e.keyExamples(), 76)))
+
+	for _, group := range commandGroups {
+		help.WriteString("\n[yellow]" + group + "[-]\n")
+		for _, def := range commands {
+			if def.group != group {
+				continue
+			}
+			help.WriteString(tview.Escape(fmt.Sprintf("  %-28s %-16s %s\n", commandUsage(def), e.keysText(def), def.desc)))
+		}
+	}
+	return help.String()
+}
+
+// keyExamples explains counts, operators, objects, Visual Mode and registers
+// with the keys bound to them right now, the shortest key of each command.
+// Sentences about commands without a key are left out.
+func (e *TextEditor) keyExamples() string {
+	key := func(mode EditorMode, name string) string {
+		shortest := ""
+		for _, def := range commands {
+			if def.name != name {
+				continue
+			}
+			for _, key := range e.keysFor(mode, def) {
+				if shortest == "" || len(key) < len(shortest) {
+					shortest = key
+				}
+			}
+		}
+		return shortest
+	}
+	down, word := key(ViewMode, "down"), key(ViewMode, "wordnext")
+	del, change, yank := key(ViewMode, "deleteto"), key(ViewMode, "changeto"), key(ViewMode, "yankto")
+	inner := key(OperatorMode, "inner")
+	visual, register := key(ViewMode, "visual"), key(ViewMode, "register")
+
+	var text []string
+	if down != "" {
+		text = append(text, fmt.Sprintf("A number typed first is a count: '5 %s' moves down five lines.", down))
+	}
+	if del != "" && word != "" {
+		text = append(text, fmt.Sprintf("The delete, change and copy keys work on the text a movement key goes over: '%s %s' deletes a word.", del, word))
+	}
+	if change != "" && inner != "" {
+		text = append(text, fmt.Sprintf("After them an object takes the inside or all of something: '%s %s (' changes what is in parentheses. Objects are w, W, quotes, brackets, t (tag) and p.", change, inner))
+	}
+	if visual != "" {
+		text = append(text, fmt.Sprintf("'%s' starts a selection that movement keys extend, and the keys marked (visual) work on it.", visual))
+	}
+	if register != "" && yank != "" {
+		text = append(text, fmt.Sprintf("'%s a %s' copies into register a and '%s +' is the system clipboard; ':registers' lists them and ':health' shows how the clipboard is reached.", register, yank, register))
+	}
+	return strings.Join(text, " ")
+}
+
+// wrapText breaks text into lines of at most width columns.
+func wrapText(text string, width int) string {
+	var result strings.Builder
+	column := 0
+	for _, word := range strings.Fields(text) {
+		switch {
+		case column == 0:
+		case column+1+len(word) > width:
+			result.WriteString("\n")
+			column = 0
+		default:
+			result.WriteString(" ")
+			column++
+		}
+		result.WriteString(word)
+		column += len(word)
+	}
+	if column > 0 {
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+// commandUsage shows how to type a command, like ":w[rite][!] [file]".
+func commandUsage(def *Command) string {
+	usage := ":" + def.name
+	if def.short != "" && def.short != def.name {
+		usage = ":" + def.short + "[" + strings.TrimPrefix(def.name, def.short) + "]"
+	}
+	for _, alias := range def.aliases {
+		usage += ", :" + alias
+	}
+	if def.bang {
+		usage += "[!]"
+	}
+	if def.argName != "" {
+		usage += " " + def.argName
+	}
+	return usage
+}
+
+// keysText lists the keys bound to a command, Edit Mode keys marked as such.
+func (e *TextEditor) keysText(def *Command) string {
+	var keys []string
+	keys = append(keys, e.keysFor(ViewMode, def)...)
+	for _, key := range e.keysFor(EditMode, def) {
+		if !contains(keys, key) {
+			keys = append(keys, key+" (edit)")
+		}
+	}
+	for _, key := range e.keysFor(VisualMode, def) {
+		if !contains(keys, key) {
+			keys = append(keys, key+" (visual)")
+		}
+	}
+	for _, key := range e.keysFor(OperatorMode, def) {
+		keys = append(keys, key+" (after d)")
+	}
+	if len(keys) > 3 {
+		keys = append(keys[:2], "…")
+	}
+	return strings.Join(keys, ", ")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Command handlers
+
+// writeTarget returns the file a write command saves to. A file that already
+// exists and isn't the buffer's own is only overwritten with '!'.
+func (e *TextEditor) writeTarget(c *exCommand) (string, error) {
+	path := expandPath(c.arg)
+	if c.bang {
+		return path, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return path, nil
+	}
+	if own, err := os.Stat(e.buf.path); err == nil && os.SameFile(info, own) {
+		return path, nil
+	}
+	return "", fmt.Errorf("%s already exists (add ! to overwrite)", c.arg)
+}
+
+func (e *TextEditor) cmdWrite(c *exCommand) error {
+	if c.arg != "" {
+		path, err := e.writeTarget(c)
+		if err != nil {
+			return err
+		}
+		e.saveAs(path)
+		return nil
+	}
+	e.saveFile()
+	return nil
+}
+
+func (e *TextEditor) cmdQuit(c *exCommand) error {
+	if c.bang {
+		e.app.Stop()
+		return nil
+	}
+	e.quit()
+	return nil
+}
+
+func (e *TextEditor) cmdWriteQuit(c *exCommand) error {
+	if c.arg != "" {
+		path, err := e.writeTarget(c)
+		if err != nil {
+			return err
+		}
+		e.saveAs(path)
+		if !e.buf.modified {
+			e.quit()
+		}
+		return nil
+	}
+	e.saveThen(e.quit)
+	return nil
+}
+
+func (e *TextEditor) cmdEdit(c *exCommand) error {
+	if c.arg != "" {
+		return e.openBuffer(expandPath(c.arg))
+	}
+	if !c.bang {
+		return fmt.Errorf("no file name")
+	}
+	return e.reloadBuffer()
+}
+
+func (e *TextEditor) cmdOpen(c *exCommand) error {
+	if c.arg != "" {
+		return e.openBuffer(expandPath(c.arg))
+	}
+	e.openFile()
+	return nil
+}
+
+func (e *TextEditor) cmdBufferDelete(c *exCommand) error {
+	if c.bang {
+		e.buf.modified = false
+	}
+	e.closeBuffer()
+	return nil
+}
+
+// cmdTab goes to the tab given as argument, or by the digit of the key that
+// ran it, like Alt+3.
+func (e *TextEditor) cmdTab(c *exCommand) error {
+	arg := c.arg
+	if arg == "" && c.key != "" {
+		arg = c.key[len(c.key)-1:]
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return fmt.Errorf("tab number expected")
+	}
+	e.gotoTab(n - 1)
+	return nil
+}
+
+// cmdFilter filters the lines of its range through a command. In Visual
+// Mode it asks for the command first.
+func (e *TextEditor) cmdFilter(c *exCommand) error {
+	if e.mode == VisualMode {
+		e.startVisualCommandLine("filter ")
+		return nil
+	}
+	command := strings.TrimSpace(c.arg)
+	if command == "" {
+		return fmt.Errorf("command expected")
+	}
+	return e.filterLines(c.start, c.end, command)
+}
+
+func (e *TextEditor) cmdSplit(c *exCommand) error {
+	e.splitWindow(strings.HasPrefix(c.name, "v"))
+	if c.arg != "" {
+		return e.openBuffer(expandPath(c.arg))
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// User configuration
+//
+// Settings are read from config.json in the config directory when the editor
+// starts and again on ":reloadconfig". Every setting is optional:
+//
+//	{
+//	  "tabWidth": 4,
+//	  "lineNumbers": true,
+//	  "scrollOff": 3,
+//	  "welcome": false,
+//	  "keyTimeout": 1000,
+//	  "clipboard": "osc52",
+//	  "colors": {"keyword": "fuchsia", "cursorLine": "#202040"},
+//	  "fileTypes": {
+//	    "go": {"tabWidth": 8},
+//	    ".md": {"lineNumbers": false}
+//	  },
+//	  "keys": {"view": {"Ctrl+S": "write"}}
+//	}
+//
+// File types are language names from the syntax files or extensions, and
+// override the settings above for the files they match. Keys are described in
+// keys.go, and clipboard providers in clipboard.go.
+
+// FileSettings are the settings a file type can override.
+type FileSettings struct {
+	TabWidth    *int  `json:"tabWidth"`
+	LineNumbers *bool `json:"lineNumbers"`
+	ScrollOff   *int  `json:"scrollOff"`
+}
+
+type Config struct {
+	FileSettings
+	KeyTimeout *int                         `json:"keyTimeout"`
+	Welcome    *bool                        `json:"welcome"`
+	Clipboard  *string                      `json:"clipboard"`
+	Colors     map[string]string            `json:"colors"`
+	FileTypes  map[string]FileSettings      `json:"fileTypes"`
+	Keys       map[string]map[string]string `json:"keys"`
+}
+
+const (
+	defaultTabWidth  = 4
+	defaultScrollOff = 3
+)
+
+// Colours of the editor itself, besides the syntax colours. Values are tview
+// colour tags: a foreground, or a foreground and background like
+// "black:white".
+var uiColors = map[string]string{
+	"lineNumber":        "-",
+	"currentLineNumber": "yellow:blue",
+	"cursorLine":        "blue",
+	"cursor":            "black:white",
+	"match":             "black:yellow",
+	"currentMatch":      "black:fuchsia",
+	"selection":         "black:aqua",
+}
+
+var (
+	defaultUIColors     = maps.Clone(uiColors)
+	defaultSyntaxColors = maps.Clone(syntaxColors)
+)
+
+// configDir returns the directory holding the user's SWIFT settings, such as
+// ~/.config/swift on Linux.
+func configDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "swift")
+}
+
+func configPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// loadConfig reads and checks the config file. A missing file is an empty
+// config.
+func loadConfig(path string, languages []*Language) (*Config, error) {
+	config := &Config{}
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return nil, fmt.Errorf("%s:%d: %v", path, lineAt(data, syntaxErr.Offset), err)
+		case errors.As(err, &typeErr):
+			return nil, fmt.Errorf("%s:%d: %s must be %s, not %s", path, lineAt(data, typeErr.Offset), typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := config.validate(languages); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// lineAt returns the line number of a byte offset in data.
+func lineAt(data []byte, offset int64) int {
+	offset = min(offset, int64(len(data)))
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func (c *Config) validate(languages []*Language) error {
+	if err := c.FileSettings.validate(); err != nil {
+		return err
+	}
+	if c.KeyTimeout != nil && (*c.KeyTimeout < 100 || *c.KeyTimeout > 10000) {
+		return fmt.Errorf("keyTimeout must be between 100 and 10000 milliseconds")
+	}
+	if c.Clipboard != nil && !slices.Contains(clipboardNames, *c.Clipboard) {
+		return fmt.Errorf("clipboard: unknown provider %q, expected one of %s", *c.Clipboard, strings.Join(clipboardNames, ", "))
+	}
+	for name, settings := range c.FileTypes {
+		if !strings.HasPrefix(name, ".") && !isLanguage(languages, name) {
+			return fmt.Errorf("fileTypes: unknown file type %q, use a language name or an extension like \".txt\"", name)
+		}
+		if err := settings.validate(); err != nil {
+			return fmt.Errorf("fileTypes.%s: %v", name, err)
+		}
+	}
+	for name, value := range c.Colors {
+		_, isSyntax := tokenKindNames[name]
+		_, isUI := uiColors[name]
+		if !isSyntax && !isUI {
+			return fmt.Errorf("colors: unknown colour %q, expected one of %s", name, strings.Join(colorNames(), ", "))
+		}
+		if err := validateColor(value); err != nil {
+			return fmt.Errorf("colors.%s: %v", name, err)
+		}
+	}
+	_, err := parseKeys(c.Keys)
+	return err
+}
+
+func (s FileSettings) validate() error {
+	if s.TabWidth != nil && (*s.TabWidth < 1 || *s.TabWidth > 16) {
+		return fmt.Errorf("tabWidth must be between 1 and 16")
+	}
+	if s.ScrollOff != nil && (*s.ScrollOff < 0 || *s.ScrollOff > 999) {
+		return fmt.Errorf("scrollOff must be between 0 and 999")
+	}
+	return nil
+}
+
+func isLanguage(languages []*Language, name string) bool {
+	for _, lang := range languages {
+		if strings.EqualFold(lang.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// colorNames lists the names the colors setting accepts.
+func colorNames() []string {
+	var names []string
+	for name := range tokenKindNames {
+		names = append(names, name)
+	}
+	for name := range uiColors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateColor checks a colour tag like "red", "#ff8000" or "black:white".
+func validateColor(value string) error {
+	parts := strings.Split(value, ":")
+	if len(parts) > 2 {
+		return fmt.Errorf("%q should be a colour or foreground:background", value)
+	}
+	for _, part := range parts {
+		if part == "" || part == "-" {
+			continue
+		}
+		if _, ok := tcell.ColorNames[strings.ToLower(part)]; ok {
+			continue
+		}
+		if len(part) == 7 && part[0] == '#' && tcell.GetColor(part) != tcell.ColorDefault {
+			continue
+		}
+		return fmt.Errorf("unknown colour %q", part)
+	}
+	return nil
+}
+
+// applyConfig makes config the editor's settings.
+func (e *TextEditor) applyConfig(config *Config) {
+	e.config = config
+	e.bindings = bindingsFor(config)
+	e.clipboard = e.chooseClipboard(config.clipboard(), detectClipboards())
+
+	uiColors = maps.Clone(defaultUIColors)
+	syntaxColors = maps.Clone(defaultSyntaxColors)
+	for name, value := range config.Colors {
+		if kind, ok := tokenKindNames[name]; ok {
+			syntaxColors[kind] = value
+		} else {
+			uiColors[name] = value
+		}
+	}
+}
+
+// reloadConfig reads the config file again. A broken file leaves the current
+// settings in place.
+func (e *TextEditor) reloadConfig() error {
+	config, err := loadConfig(configPath(), e.languages)
+	if err != nil {
+		return err
+	}
+	e.applyConfig(config)
+	e.updateDisplay()
+	e.updateStatusBar("Config reloaded")
+	return nil
+}
+
+// fileSettings returns the file type overrides that apply to b, the language
+// name before the extension.
+func (e *TextEditor) fileSettings(b *Buffer) []FileSettings {
+	var settings []FileSettings
+	if b.path == "" {
+		return settings
+	}
+	if lang := languageFor(e.languages, b.path); lang != nil {
+		for name, s := range e.config.FileTypes {
+			if strings.EqualFold(name, lang.Name) {
+				settings = append(settings, s)
+			}
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(b.path))
+	for name, s := range e.config.FileTypes {
+		if strings.ToLower(name) == ext {
+			settings = append(settings, s)
+		}
+	}
+	return settings
+}
+
+// tabWidth returns how many spaces Tab inserts in b.
+func (e *TextEditor) tabWidth(b *Buffer) int {
+	width := defaultTabWidth
+	if e.config.TabWidth != nil {
+		width = *e.config.TabWidth
+	}
+	for _, s := range e.fileSettings(b) {
+		if s.TabWidth != nil {
+			width = *s.TabWidth
+		}
+	}
+	return width
+}
+
+// lineNumbers reports whether windows on b show line numbers.
+func (e *TextEditor) lineNumbers(b *Buffer) bool {
+	show := true
+	if e.config.LineNumbers != nil {
+		show = *e.config.LineNumbers
+	}
+	for _, s := range e.fileSettings(b) {
+		if s.LineNumbers != nil {
+			show = *s.LineNumbers
+		}
+	}
+	return show
+}
+
+// scrollOff returns how many lines windows on b keep visible above and below
+// the cursor. The -scrolloff flag wins over the config file.
+func (e *TextEditor) scrollOff(b *Buffer) int {
+	if e.scrollOffFlag != nil {
+		return *e.scrollOffFlag
+	}
+	lines := defaultScrollOff
+	if e.config.ScrollOff != nil {
+		lines = *e.config.ScrollOff
+	}
+	for _, s := range e.fileSettings(b) {
+		if s.ScrollOff != nil {
+			lines = *s.ScrollOff
+		}
+	}
+	return lines
+}
+
+// welcome reports whether the welcome screen is shown when no file is given.
+func (c *Config) welcome() bool {
+	return c.Welcome == nil || *c.Welcome
+}
+
+// clipboard returns the name of the clipboard provider to use.
+func (c *Config) clipboard() string {
+	if c.Clipboard == nil {
+		return "auto"
+	}
+	return *c.Clipboard
+}
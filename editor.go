@@ -16,72 +16,126 @@ type EditorMode int
 const (
 	ViewMode EditorMode = iota
 	EditMode
+	VisualMode
+	OperatorMode // Keys that change meaning in View Mode while an operator waits
 )
 
 // Enhanced text editor with Vim-like modes
 type TextEditor struct {
 	app           *tview.Application
-	textView      *tview.TextView
+	windows       []*Window
+	win           *Window
+	layout        *layoutNode
+	tabBar        *TabBar
 	statusBar     *tview.TextView
-	helpModal     *tview.Modal
+	prompt        *tview.InputField
+	help          *tview.TextView
 	fileModal     *tview.Modal
 	saveForm      *tview.Form
 	openForm      *tview.Form
-	filePath      string
-	content       []string
+	buffers       []*Buffer
+	buf           *Buffer
+	languages     []*Language
 	lineNum       int
 	colNum        int
+	topLine       int
+	scrollOffFlag *int
 	showHelp      bool
 	showWelcome   bool
-	modified      bool
 	mode          EditorMode
-	commandBuffer string
 	showingDialog bool
-}
-
-func NewTextEditor(filePath string) *TextEditor {
+	bindings      map[EditorMode]keymap
+	pendingKeys   string
+	keyTimer      int
+	count         int
+	operator      *operator
+	selection     selection
+	awaiting      string
+	registers     map[rune]register
+	regName       rune // Register picked for the next command, 0 if none
+	prompting     bool
+	afterSave     func()
+	capture       func(event *tcell.EventKey) *tcell.EventKey
+	search        *searchState
+	searchRegex   bool
+	showMatches   bool
+	currentMatch  *[3]int // line and byte range of the match :s///c asks about
+	recentFiles   []string
+	config        *Config
+	clipboard     Clipboard
+}
+
+func NewTextEditor(filePaths []string) *TextEditor {
 	app := tview.NewApplication()
 
+	syntaxDir := ""
+	if dir := configDir(); dir != "" {
+		syntaxDir = filepath.Join(dir, "syntax")
+	}
+	languages, syntaxErrors := loadLanguages(syntaxDir)
+	config, configErr := loadConfig(configPath(), languages)
+
 	editor := &TextEditor{
 		app:           app,
-		filePath:      filePath,
-		content:       []string{""},
+		languages:     languages,
 		lineNum:       0,
 		colNum:        0,
-		showWelcome:   filePath == "",
-		modified:      false,
+		topLine:       0,
 		mode:          ViewMode,
-		commandBuffer: "",
 		showingDialog: false,
+		recentFiles:   loadRecentFiles(),
 	}
 
-	editor.setupUI()
+	// A broken config is reported and the defaults used instead
+	if configErr != nil {
+		config = &Config{}
+	}
+	editor.applyConfig(config)
+
+	editor.setupUI(filePaths)
+
+	// Broken syntax files are skipped, but say so
+	if len(syntaxErrors) > 0 {
+		editor.updateStatusBar(fmt.Sprintf("Syntax file error: %v (%d total)", syntaxErrors[0], len(syntaxErrors)))
+	}
+	if configErr != nil {
+		editor.updateStatusBar(fmt.Sprintf("Config error: %v", configErr))
+	}
 	return editor
 }
 
-func (e *TextEditor) setupUI() {
-	// Create main text view with better configuration
-	e.textView = tview.NewTextView().
-		SetDynamicColors(true).
-		SetRegions(true).
-		SetWordWrap(false).
-		SetScrollable(true).
-		SetChangedFunc(func() {
-			e.app.Draw()
+func (e *TextEditor) setupUI(filePaths []string) {
+	// Start with one window filling the text area
+	e.win = e.newWindow(nil)
+	e.layout = &layoutNode{window: e.win, weight: windowWeight}
+	e.win.node = e.layout
+
+	// Create tab bar with the open buffers
+	e.tabBar = NewTabBar().
+		SetSelectedFunc(e.gotoTab).
+		SetClosedFunc(func(index int) {
+			e.gotoTab(index)
+			e.closeBuffer()
 		})
 
 	// Create status bar with more information
 	e.statusBar = tview.NewTextView().
 		SetDynamicColors(true).
 		SetTextAlign(tview.AlignLeft)
+	e.prompt = e.newPrompt()
 
-	// Create help modal
-	e.helpModal = tview.NewModal().
-		SetText(e.getHelpText()).
-		AddButtons([]string{"Close"}).
-		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
-			e.showHelp = false
-			e.app.SetRoot(e.getMainLayout(), true)
+	// Create help view, closed with Esc, Enter or q
+	e.help = tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true)
+	e.help.SetBorder(true).
+		SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+			if event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyEnter || event.Rune() == 'q' {
+				e.showHelp = false
+				e.app.SetRoot(e.getMainLayout(), true)
+				return nil
+			}
+			return event
 		})
 
 	// Create save form
@@ -90,13 +144,19 @@ func (e *TextEditor) setupUI() {
 	e.saveForm.AddButton("Save", func() {
 		filename := e.saveForm.GetFormItem(0).(*tview.InputField).GetText()
 		if filename != "" {
-			e.filePath = filename
-			e.saveFile()
+			e.saveAs(filename)
 		}
 		e.showingDialog = false
 		e.app.SetRoot(e.getMainLayout(), true)
+
+		// Continue a quit, open or new that was waiting for the save
+		if action := e.afterSave; action != nil && !e.buf.modified {
+			e.afterSave = nil
+			action()
+		}
 	})
 	e.saveForm.AddButton("Cancel", func() {
+		e.afterSave = nil
 		e.showingDialog = false
 		e.app.SetRoot(e.getMainLayout(), true)
 	})
@@ -108,12 +168,13 @@ func (e *TextEditor) setupUI() {
 	e.openForm.AddInputField("Open file:", "", 50, nil, nil)
 	e.openForm.AddButton("Open", func() {
 		filename := e.openForm.GetFormItem(0).(*tview.InputField).GetText()
-		if filename != "" {
-			e.filePath = filename
-			e.loadFile()
-		}
 		e.showingDialog = false
 		e.app.SetRoot(e.getMainLayout(), true)
+		if filename != "" {
+			if err := e.openBuffer(filename); err != nil {
+				e.updateStatusBar(fmt.Sprintf("Error: %v", err))
+			}
+		}
 	})
 	e.openForm.AddButton("Cancel", func() {
 		e.showingDialog = false
@@ -122,15 +183,25 @@ func (e *TextEditor) setupUI() {
 	e.openForm.SetBorder(true)
 	e.openForm.SetTitle("Open File")
 
-	// Set up enhanced key bindings
-	e.setupKeyBindings()
-
-	// Load file if specified
-	if e.filePath != "" {
-		e.loadFile()
-	} else {
+	// Load files if specified
+	var loadErr error
+	for _, path := range filePaths {
+		if err := e.openBuffer(path); err != nil {
+			loadErr = err
+		}
+	}
+	if len(e.buffers) == 0 {
+		e.buffers = []*Buffer{e.newBuffer("", "")}
+	}
+	// Start on the first file given on the command line
+	e.switchBuffer(e.buffers[0])
+	if len(filePaths) == 0 && e.config.welcome() {
+		e.showWelcome = true
 		e.showWelcomeScreen()
 	}
+	if loadErr != nil {
+		e.updateStatusBar(fmt.Sprintf("Error: %v", loadErr))
+	}
 
 	// Set initial layout
 	e.app.SetRoot(e.getMainLayout(), true)
@@ -138,7 +209,7 @@ func (e *TextEditor) setupUI() {
 
 func (e *TextEditor) getMainLayout() tview.Primitive {
 	if e.showHelp {
-		return e.helpModal
+		return e.help
 	}
 
 	if e.showingDialog {
@@ -146,11 +217,16 @@ func (e *TextEditor) getMainLayout() tview.Primitive {
 		return nil
 	}
 
-	// Create flex layout with line numbers
+	// Create flex layout with the windows between the tab and status bars
 	flex := tview.NewFlex().
 		SetDirection(tview.FlexRow).
-		AddItem(e.textView, 0, 1, true).
-		AddItem(e.statusBar, 1, 0, false)
+		AddItem(e.tabBar, 1, 0, false).
+		AddItem(e.layoutPrimitive(e.layout), 0, 1, !e.prompting)
+	if e.prompting {
+		flex.AddItem(e.prompt, 1, 0, true)
+	} else {
+		flex.AddItem(e.statusBar, 1, 0, false)
+	}
 
 	return flex
 }
@@ -179,58 +255,27 @@ func (e *TextEditor) showWelcomeScreen() {
 ║                                                              ║
 ╚══════════════════════════════════════════════════════════════╝
 `
-	e.textView.SetText(welcomeText)
+	e.win.view.ShowText(welcomeText)
 	e.updateStatusBar("Welcome to SWIFT! Press 'g' for help")
 }
 
-func (e *TextEditor) setupKeyBindings() {
-	e.textView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		// Handle mode-specific behavior
-		if e.mode == EditMode {
-			return e.handleEditMode(event)
-		} else {
-			return e.handleViewMode(event)
-		}
-	})
+// handleKey is the input handler of every window.
+func (e *TextEditor) handleKey(event *tcell.EventKey) *tcell.EventKey {
+	// A question like the substitute confirmation takes every key
+	if e.capture != nil {
+		return e.capture(event)
+	}
+
+	// Handle mode-specific behavior
+	if e.mode == EditMode {
+		return e.handleEditMode(event)
+	} else {
+		return e.handleViewMode(event)
+	}
 }
 
 func (e *TextEditor) handleEditMode(event *tcell.EventKey) *tcell.EventKey {
-	// In Edit Mode, only handle text input and ESC to exit
-	switch event.Key() {
-	case tcell.KeyEscape:
-		// Exit Edit Mode
-		e.mode = ViewMode
-		e.updateStatusBar("View Mode")
-		return nil
-	case tcell.KeyUp:
-		e.moveUp()
-		return nil
-	case tcell.KeyDown:
-		e.moveDown()
-		return nil
-	case tcell.KeyLeft:
-		e.moveLeft()
-		return nil
-	case tcell.KeyRight:
-		e.moveRight()
-		return nil
-	case tcell.KeyHome:
-		e.moveToLineStart()
-		return nil
-	case tcell.KeyEnd:
-		e.moveToLineEnd()
-		return nil
-	case tcell.KeyEnter:
-		e.insertNewline()
-		return nil
-	case tcell.KeyBackspace, tcell.KeyBackspace2:
-		e.backspace()
-		return nil
-	case tcell.KeyDelete:
-		e.delete()
-		return nil
-	case tcell.KeyTab:
-		e.insertTab()
+	if e.dispatchKey(EditMode, event) {
 		return nil
 	}
 
@@ -244,174 +289,86 @@ func (e *TextEditor) handleEditMode(event *tcell.EventKey) *tcell.EventKey {
 }
 
 func (e *TextEditor) handleViewMode(event *tcell.EventKey) *tcell.EventKey {
-	// Handle special keys
-	switch event.Key() {
-	case tcell.KeyCtrlQ:
-		if e.modified {
-			// TODO: Add unsaved changes warning
-		}
-		e.app.Stop()
-		return nil
-	case tcell.KeyUp:
-		e.moveUp()
-		return nil
-	case tcell.KeyDown:
-		e.moveDown()
-		return nil
-	case tcell.KeyLeft:
-		e.moveLeft()
+	// The welcome screen promises help on 'g'
+	if e.showWelcome && event.Key() == tcell.KeyRune && event.Rune() == 'g' {
+		e.openHelp()
 		return nil
-	case tcell.KeyRight:
-		e.moveRight()
-		return nil
-	case tcell.KeyHome:
-		e.moveToLineStart()
-		return nil
-	case tcell.KeyEnd:
-		e.moveToLineEnd()
-		return nil
-	case tcell.KeyEnter:
-		// Execute command buffer
-		e.executeCommand()
+	}
+
+	if e.dispatchKey(e.mode, event) {
 		return nil
 	}
 
-	// Handle regular characters for commands
-	if event.Rune() != 0 {
-		switch event.Rune() {
-		case 'i':
-			// Enter Edit Mode
-			e.mode = EditMode
-			e.updateStatusBar("Edit Mode - Press ESC to exit")
-			return nil
-		case 'g':
-			if e.showWelcome {
-				e.showHelp = true
-				e.app.SetRoot(e.helpModal, true)
-				return nil
-			}
-		case 'h':
-			e.showHelp = true
-			e.app.SetRoot(e.helpModal, true)
-			return nil
-		default:
-			// Add to command buffer
-			e.commandBuffer += string(event.Rune())
-			e.updateStatusBar(fmt.Sprintf("View Mode - Command: %s", e.commandBuffer))
-			return nil
-		}
+	// Letters without a binding do nothing
+	if event.Key() == tcell.KeyRune {
+		return nil
 	}
 
 	return event
 }
 
-func (e *TextEditor) executeCommand() {
-	command := e.commandBuffer
-	e.commandBuffer = ""
+func (e *TextEditor) enterEditMode() {
+	e.mode = EditMode
+	e.updateStatusBar("Edit Mode - Press ESC to exit")
+}
 
-	switch command {
-	case "q":
-		if e.showWelcome {
-			e.app.Stop()
-		} else {
-			e.app.Stop()
-		}
-	case "w":
-		e.saveFile()
-	case "wq":
-		e.saveFile()
-		e.app.Stop()
-	case "o":
-		e.openFile()
-	case "n":
-		e.newFile()
-	case "h":
-		e.showHelp = true
-		e.app.SetRoot(e.helpModal, true)
-	default:
-		e.updateStatusBar(fmt.Sprintf("Unknown command: %s", command))
-	}
-}
-
-func (e *TextEditor) getHelpText() string {
-	return `
-╔══════════════════════════════════════════════════════════════╗
-║                    SWIFT Help & Commands                    ║
-╠══════════════════════════════════════════════════════════════╣
-║                                                              ║
-║  🎯 MODES (Vim-like):                                       ║
-║  • View Mode: Navigate and execute commands                 ║
-║  • Edit Mode: Insert and edit text (press 'i' to enter)    ║
-║  • ESC: Exit Edit Mode and return to View Mode             ║
-║                                                              ║
-║  🎯 NAVIGATION (Mac Compatible):                            ║
-║  • Arrow Keys: Move cursor in all directions                ║
-║  • Home/End: Jump to beginning/end of line                  ║
-║  • Ctrl+Home/End: Jump to start/end of file                 ║
-║                                                              ║
-║  📝 EDITING (Edit Mode Only):                               ║
-║  • Type normally to insert text                             ║
-║  • Backspace/Delete: Remove characters                      ║
-║  • Enter: New line                                          ║
-║  • Tab: Smart indentation (4 spaces)                        ║
-║  • ESC: Exit Edit Mode                                      ║
-║                                                              ║
-║  💾 COMMANDS (View Mode + Enter):                           ║
-║  • 'w' + Enter: Save file (prompts for filename)            ║
-║  • 'q' + Enter: Quit                                        ║
-║  • 'wq' + Enter: Save and quit                              ║
-║  • 'o' + Enter: Open file (prompts for path)                ║
-║  • 'n' + Enter: New file                                    ║
-║  • 'h' + Enter: Show this help                              ║
-║                                                              ║
-║  🎨 FEATURES:                                               ║
-║  • Syntax highlighting for many languages                   ║
-║  • Vim-like modes (View/Edit)                               ║
-║  • Mac keyboard compatible                                  ║
-║  • Mouse-free operation                                     ║
-║  • Smart indentation                                        ║
-║                                                              ║
-║  💡 TIP: Press 'i' to edit, ESC to return to view mode!    ║
-║                                                              ║
-╚══════════════════════════════════════════════════════════════╝
-`
+func (e *TextEditor) enterViewMode() {
+	e.buf.history.Close()
+	e.mode = ViewMode
+	e.updateStatusBar("View Mode")
+}
+
+// openHelp shows the help, generated from the command registry.
+func (e *TextEditor) openHelp() {
+	e.showText(" Help - Esc to close ", e.getHelpText())
+}
+
+// showText shows a page of text in the help view.
+func (e *TextEditor) showText(title, text string) {
+	e.help.SetText(text).ScrollToBeginning()
+	e.help.SetTitle(title)
+	e.showHelp = true
+	e.app.SetRoot(e.help, true)
 }
 
 // Movement functions
 func (e *TextEditor) moveUp() {
+	e.buf.history.Close()
 	if e.lineNum > 0 {
+		target := displayCol(e.buf.text.Line(e.lineNum), e.colNum)
 		e.lineNum--
-		if e.colNum >= len(e.content[e.lineNum]) {
-			e.colNum = len(e.content[e.lineNum])
-		}
+		e.colNum = colForDisplay(e.buf.text.Line(e.lineNum), target)
 		e.updateDisplay()
 	}
 }
 
 func (e *TextEditor) moveDown() {
-	if e.lineNum < len(e.content)-1 {
+	e.buf.history.Close()
+	if e.lineNum < e.buf.text.LineCount()-1 {
+		target := displayCol(e.buf.text.Line(e.lineNum), e.colNum)
 		e.lineNum++
-		if e.colNum >= len(e.content[e.lineNum]) {
-			e.colNum = len(e.content[e.lineNum])
-		}
+		e.colNum = colForDisplay(e.buf.text.Line(e.lineNum), target)
 		e.updateDisplay()
 	}
 }
 
 func (e *TextEditor) moveLeft() {
+	e.buf.history.Close()
 	if e.colNum > 0 {
-		e.colNum--
+		e.colNum = prevGraphemeCol(e.buf.text.Line(e.lineNum), e.colNum)
 	} else if e.lineNum > 0 {
 		e.lineNum--
-		e.colNum = len(e.content[e.lineNum])
+		e.colNum = runeLen(e.buf.text.Line(e.lineNum))
 	}
 	e.updateDisplay()
 }
 
 func (e *TextEditor) moveRight() {
-	if e.colNum < len(e.content[e.lineNum]) {
-		e.colNum++
-	} else if e.lineNum < len(e.content)-1 {
+	e.buf.history.Close()
+	line := e.buf.text.Line(e.lineNum)
+	if e.colNum < runeLen(line) {
+		e.colNum = nextGraphemeCol(line, e.colNum)
+	} else if e.lineNum < e.buf.text.LineCount()-1 {
 		e.lineNum++
 		e.colNum = 0
 	}
@@ -419,30 +376,76 @@ func (e *TextEditor) moveRight() {
 }
 
 func (e *TextEditor) moveToLineStart() {
+	e.buf.history.Close()
 	e.colNum = 0
 	e.updateDisplay()
 }
 
 func (e *TextEditor) moveToLineEnd() {
-	e.colNum = len(e.content[e.lineNum])
+	e.buf.history.Close()
+	e.colNum = runeLen(e.buf.text.Line(e.lineNum))
 	e.updateDisplay()
 }
 
 // Page Up/Down removed for Mac keyboard compatibility
 
+// cursorOffset returns the byte offset of the cursor in the buffer.
+func (e *TextEditor) cursorOffset() int {
+	return e.buf.text.LineStart(e.lineNum) + byteOffset(e.buf.text.Line(e.lineNum), e.colNum)
+}
+
+// setCursor moves the cursor to pos, clamped to the buffer contents.
+func (e *TextEditor) setCursor(pos cursorPos) {
+	e.lineNum = min(max(pos.line, 0), e.buf.text.LineCount()-1)
+	e.colNum = min(max(pos.col, 0), runeLen(e.buf.text.Line(e.lineNum)))
+}
+
+// insertText and deleteText are the only way editing functions change the
+// buffer, so that every change ends up in the undo history.
+func (e *TextEditor) insertText(offset int, text string) {
+	if text == "" {
+		return
+	}
+	e.buf.history.Record(editOp{offset: offset, inserted: text}, cursorPos{e.lineNum, e.colNum})
+	e.buf.text.Insert(offset, text)
+}
+
+func (e *TextEditor) deleteText(offset, length int) {
+	deleted := e.buf.text.Slice(offset, offset+length)
+	if deleted == "" {
+		return
+	}
+	e.buf.history.Record(editOp{offset: offset, deleted: deleted}, cursorPos{e.lineNum, e.colNum})
+	e.buf.text.Delete(offset, len(deleted))
+}
+
+// replaceText replaces length bytes at offset with text as a single change.
+func (e *TextEditor) replaceText(offset, length int, text string) {
+	deleted := e.buf.text.Slice(offset, offset+length)
+	if deleted == text {
+		return
+	}
+	e.buf.history.Record(editOp{offset: offset, deleted: deleted, inserted: text}, cursorPos{e.lineNum, e.colNum})
+	e.buf.text.Delete(offset, len(deleted))
+	e.buf.text.Insert(offset, text)
+}
+
+// editDone is called once an edit has moved the cursor to its new position.
+func (e *TextEditor) editDone() {
+	e.buf.modified = true
+	e.buf.history.SetCursor(cursorPos{e.lineNum, e.colNum})
+	e.updateDisplay()
+}
+
 // Editing functions
 func (e *TextEditor) insertChar(char rune) {
 	if e.showWelcome {
 		return
 	}
 
-	line := e.content[e.lineNum]
-	before := line[:e.colNum]
-	after := line[e.colNum:]
-	e.content[e.lineNum] = before + string(char) + after
+	e.insertText(e.cursorOffset(), string(char))
 	e.colNum++
-	e.modified = true
-	e.updateDisplay()
+	e.editDone()
 }
 
 func (e *TextEditor) insertNewline() {
@@ -450,19 +453,10 @@ func (e *TextEditor) insertNewline() {
 		return
 	}
 
-	line := e.content[e.lineNum]
-	before := line[:e.colNum]
-	after := line[e.colNum:]
-
-	e.content[e.lineNum] = before
-	newLine := after
-
-	// Insert new line
-	e.content = append(e.content[:e.lineNum+1], append([]string{newLine}, e.content[e.lineNum+1:]...)...)
+	e.insertText(e.cursorOffset(), "\n")
 	e.lineNum++
 	e.colNum = 0
-	e.modified = true
-	e.updateDisplay()
+	e.editDone()
 }
 
 func (e *TextEditor) backspace() {
@@ -471,23 +465,21 @@ func (e *TextEditor) backspace() {
 	}
 
 	if e.colNum > 0 {
-		line := e.content[e.lineNum]
-		before := line[:e.colNum-1]
-		after := line[e.colNum:]
-		e.content[e.lineNum] = before + after
-		e.colNum--
-		e.modified = true
+		// Remove the whole grapheme cluster before the cursor
+		line := e.buf.text.Line(e.lineNum)
+		prev := prevGraphemeCol(line, e.colNum)
+		start := byteOffset(line, prev)
+		e.deleteText(e.buf.text.LineStart(e.lineNum)+start, byteOffset(line, e.colNum)-start)
+		e.colNum = prev
+		e.editDone()
 	} else if e.lineNum > 0 {
 		// Join with previous line
-		prevLine := e.content[e.lineNum-1]
-		currentLine := e.content[e.lineNum]
-		e.content[e.lineNum-1] = prevLine + currentLine
-		e.content = append(e.content[:e.lineNum], e.content[e.lineNum+1:]...)
+		prevLen := runeLen(e.buf.text.Line(e.lineNum - 1))
+		e.deleteText(e.cursorOffset()-1, 1)
 		e.lineNum--
-		e.colNum = len(prevLine)
-		e.modified = true
+		e.colNum = prevLen
+		e.editDone()
 	}
-	e.updateDisplay()
 }
 
 func (e *TextEditor) delete() {
@@ -495,20 +487,18 @@ func (e *TextEditor) delete() {
 		return
 	}
 
-	line := e.content[e.lineNum]
-	if e.colNum < len(line) {
-		before := line[:e.colNum]
-		after := line[e.colNum+1:]
-		e.content[e.lineNum] = before + after
-		e.modified = true
-	} else if e.lineNum < len(e.content)-1 {
+	line := e.buf.text.Line(e.lineNum)
+	if e.colNum < runeLen(line) {
+		// Remove the whole grapheme cluster under the cursor
+		start := byteOffset(line, e.colNum)
+		end := byteOffset(line, nextGraphemeCol(line, e.colNum))
+		e.deleteText(e.buf.text.LineStart(e.lineNum)+start, end-start)
+		e.editDone()
+	} else if e.lineNum < e.buf.text.LineCount()-1 {
 		// Join with next line
-		nextLine := e.content[e.lineNum+1]
-		e.content[e.lineNum] = line + nextLine
-		e.content = append(e.content[:e.lineNum+1], e.content[e.lineNum+2:]...)
-		e.modified = true
+		e.deleteText(e.cursorOffset(), 1)
+		e.editDone()
 	}
-	e.updateDisplay()
 }
 
 func (e *TextEditor) insertTab() {
@@ -516,212 +506,246 @@ func (e *TextEditor) insertTab() {
 		return
 	}
 
-	// Smart indentation - insert 4 spaces
-	for i := 0; i < 4; i++ {
+	// Smart indentation - insert spaces up to the tab width
+	for i := 0; i < e.tabWidth(e.buf); i++ {
 		e.insertChar(' ')
 	}
 }
 
-func (e *TextEditor) updateDisplay() {
+// deleteLines deletes lines start to end, both included, in one change.
+func (e *TextEditor) deleteLines(start, end int) {
 	if e.showWelcome {
 		return
 	}
 
-	// Create display with line numbers, syntax highlighting, and cursor indicator
-	var display strings.Builder
-
-	for i, line := range e.content {
-		// Add line number with highlighting for current line
-		if i == e.lineNum {
-			display.WriteString(fmt.Sprintf("[yellow:blue]%3d[white] | ", i+1))
-		} else {
-			display.WriteString(fmt.Sprintf("%3d | ", i+1))
-		}
-
-		// Add syntax highlighted line with cursor indicator
-		if i == e.lineNum {
-			// Current line - show cursor position and highlight line background only
-			display.WriteString("[white:blue]" + e.highlightLineWithCursor(line) + "[white]")
-		} else {
-			// Other lines - normal highlighting
-			display.WriteString(e.highlightLine(line))
-		}
-		display.WriteString("\n")
-	}
-
-	e.textView.SetText(display.String())
-
-	// Update status bar with mode information
-	modeText := "View Mode"
-	if e.mode == EditMode {
-		modeText = "Edit Mode"
+	e.buf.history.Close()
+	from := e.buf.text.LineStart(start)
+	to := e.buf.text.Len()
+	if end+1 < e.buf.text.LineCount() {
+		to = e.buf.text.LineStart(end + 1)
+	} else if start > 0 {
+		// Deleting the last lines takes the line break before them
+		from--
 	}
+	e.deleteText(from, to-from)
+	e.setCursor(cursorPos{start, 0})
+	e.editDone()
+	e.buf.history.Close()
+}
 
-	status := fmt.Sprintf("SWIFT | %s | %s | Line %d, Col %d",
-		e.getStatusText(), modeText, e.lineNum+1, e.colNum+1)
-	if e.modified {
-		status += " | MODIFIED"
+// Undo and redo
+func (e *TextEditor) undo() {
+	if e.showWelcome {
+		return
 	}
-	e.statusBar.SetText(status)
-}
 
-func (e *TextEditor) getStatusText() string {
-	if e.filePath == "" {
-		return "Untitled"
+	pos, ok := e.buf.history.Undo(e.buf.text)
+	if !ok {
+		e.updateStatusBar("Already at oldest change")
+		return
 	}
-	return filepath.Base(e.filePath)
+	e.setCursor(pos)
+	e.buf.modified = !e.buf.history.AtSaved()
+	e.updateDisplay()
 }
 
-func (e *TextEditor) highlightLine(line string) string {
-	// Simple syntax highlighting based on file extension
-	if e.filePath == "" {
-		return line
+func (e *TextEditor) redo() {
+	if e.showWelcome {
+		return
 	}
 
-	ext := strings.ToLower(filepath.Ext(e.filePath))
-
-	switch ext {
-	case ".go":
-		return e.highlightGo(line)
-	case ".py":
-		return e.highlightPython(line)
-	case ".js", ".ts":
-		return e.highlightJavaScript(line)
-	case ".html", ".htm":
-		return e.highlightHTML(line)
-	case ".css":
-		return e.highlightCSS(line)
-	case ".json":
-		return e.highlightJSON(line)
-	default:
-		return line
+	pos, ok := e.buf.history.Redo(e.buf.text)
+	if !ok {
+		e.updateStatusBar("Already at newest change")
+		return
 	}
+	e.setCursor(pos)
+	e.buf.modified = !e.buf.history.AtSaved()
+	e.updateDisplay()
 }
 
-func (e *TextEditor) highlightLineWithCursor(line string) string {
-	// Add cursor indicator at the current column position
-	if e.colNum >= len(line) {
-		// Cursor at end of line - add cursor indicator after highlighting
-		highlighted := e.highlightLine(line)
-		return highlighted + "[black:white]▌[white]"
-	} else {
-		// Cursor in middle of line - split line and highlight each part
-		before := line[:e.colNum]
-		after := line[e.colNum:]
-
-		// Highlight the parts separately to avoid color code issues
-		highlightedBefore := e.highlightLine(before)
-		highlightedAfter := e.highlightLine(after)
-
-		return highlightedBefore + "[black:white]▌[white]" + highlightedAfter
+func (e *TextEditor) updateDisplay() {
+	if e.showWelcome {
+		return
 	}
-}
 
-func (e *TextEditor) highlightGo(line string) string {
-	// Simple Go syntax highlighting
-	keywords := []string{"package", "import", "func", "var", "const", "type", "struct", "interface", "if", "else", "for", "range", "return", "go", "defer", "select", "case", "default", "switch", "break", "continue", "fallthrough"}
+	// The text itself is rendered by the viewports on their next draw
+	for _, w := range e.windows {
+		w.view.SetRenderer(w.render)
+	}
+	e.updateWindowLabels()
+	e.updateTabBar()
 
-	result := line
-	for _, keyword := range keywords {
-		result = strings.ReplaceAll(result, keyword, fmt.Sprintf("[blue]%s[white]", keyword))
+	// Update status bar with mode information
+	modeText := "View Mode"
+	switch e.mode {
+	case EditMode:
+		modeText = "Edit Mode"
+	case VisualMode:
+		modeText = visualNames[e.selection.kind]
 	}
 
-	// Highlight strings
-	result = strings.ReplaceAll(result, "\"", "[green]\"[white]")
+	name := e.getStatusText()
+	if len(e.buffers) > 1 {
+		name += fmt.Sprintf(" (%d/%d)", e.bufferIndex(e.buf)+1, len(e.buffers))
+	}
 
-	return result
+	col := displayCol(e.buf.text.Line(e.lineNum), e.colNum)
+	status := fmt.Sprintf("SWIFT | %s | %s | Line %d, Col %d",
+		name, modeText, e.lineNum+1, col+1)
+	if e.buf.modified {
+		status += " | MODIFIED"
+	}
+	if pending := e.pendingText(); pending != "" {
+		status += " | " + tview.Escape(pending)
+	}
+	e.statusBar.SetText(status)
 }
 
-func (e *TextEditor) highlightPython(line string) string {
-	keywords := []string{"def", "class", "if", "else", "elif", "for", "while", "import", "from", "return", "yield", "try", "except", "finally", "with", "as", "pass", "break", "continue", "and", "or", "not", "in", "is", "lambda", "True", "False", "None"}
+// renderWindow returns the lines of w that fit in height rows. The active
+// window scrolls so the cursor stays visible; the others show the cursor line
+// without the cursor.
+func (e *TextEditor) renderWindow(w *Window, width, height int) string {
+	if w.buf == nil {
+		return ""
+	}
 
-	result := line
-	for _, keyword := range keywords {
-		result = strings.ReplaceAll(result, keyword, fmt.Sprintf("[blue]%s[white]", keyword))
+	b := w.buf
+	lineCount := b.text.LineCount()
+	var top, cursorLine int
+	if w == e.win {
+		e.topLine = scrollToCursor(e.topLine, e.lineNum, height, e.scrollOff(b), lineCount)
+		top, cursorLine = e.topLine, e.lineNum
+	} else {
+		top, cursorLine = b.text.LineOf(w.pos.top), b.text.LineOf(w.pos.cursor)
 	}
 
-	return result
-}
+	// Create display with line numbers, syntax highlighting, and cursor indicator
+	var display strings.Builder
+	numbers := e.lineNumbers(b)
 
-func (e *TextEditor) highlightJavaScript(line string) string {
-	keywords := []string{"function", "var", "let", "const", "if", "else", "for", "while", "return", "class", "extends", "import", "export", "async", "await", "try", "catch", "finally", "throw", "new", "this", "true", "false", "null", "undefined"}
+	for i := top; i < lineCount && i < top+height; i++ {
+		// Add line number with highlighting for current line
+		switch {
+		case !numbers:
+		case i == cursorLine:
+			display.WriteString(fmt.Sprintf("[%s]%3d[white] | ", uiColors["currentLineNumber"], i+1))
+		default:
+			display.WriteString(fmt.Sprintf("[%s]%3d[-] | ", uiColors["lineNumber"], i+1))
+		}
 
-	result := line
-	for _, keyword := range keywords {
-		result = strings.ReplaceAll(result, keyword, fmt.Sprintf("[blue]%s[white]", keyword))
+		// Add syntax highlighted line with cursor indicator
+		if w == e.win && i == e.lineNum {
+			// Current line - show cursor position and highlight line background only
+			display.WriteString(e.highlightLineWithCursor(i))
+		} else {
+			// Other lines - normal highlighting
+			display.WriteString(e.highlightLine(b, i))
+		}
+		display.WriteString("\n")
 	}
 
-	return result
+	return display.String()
 }
 
-func (e *TextEditor) highlightHTML(line string) string {
-	// Simple HTML highlighting
-	result := line
-	result = strings.ReplaceAll(result, "<", "[red]<[white]")
-	result = strings.ReplaceAll(result, ">", "[red]>[white]")
-	return result
+// SetScrollOff sets how many lines are kept visible above and below the
+// cursor, in place of the scrollOff setting of the config file.
+func (e *TextEditor) SetScrollOff(lines int) {
+	lines = max(lines, 0)
+	e.scrollOffFlag = &lines
 }
 
-func (e *TextEditor) highlightCSS(line string) string {
-	// Simple CSS highlighting
-	keywords := []string{"color", "background", "margin", "padding", "border", "width", "height", "display", "position", "float", "clear", "font", "text", "line", "letter", "word", "white", "space", "overflow", "visibility", "opacity", "z-index"}
-
-	result := line
-	for _, keyword := range keywords {
-		result = strings.ReplaceAll(result, keyword, fmt.Sprintf("[blue]%s[white]", keyword))
-	}
+func (e *TextEditor) getStatusText() string {
+	return tview.Escape(e.buf.name())
+}
 
-	return result
+func (e *TextEditor) highlightLine(b *Buffer, n int) string {
+	line := b.text.Line(n)
+	return renderTokens(line, b.syntax.Tokens(n), e.matchesIn(line), e.currentMatchIn(b, n), e.selectedIn(b, n), -1, "-")
 }
 
-func (e *TextEditor) highlightJSON(line string) string {
-	// Simple JSON highlighting
-	result := line
-	result = strings.ReplaceAll(result, "\"", "[green]\"[white]")
-	result = strings.ReplaceAll(result, ":", "[yellow]:[white]")
-	result = strings.ReplaceAll(result, ",", "[yellow],[white]")
-	return result
+func (e *TextEditor) highlightLineWithCursor(n int) string {
+	// Add cursor indicator at the current column position over the current
+	// line background
+	line := e.buf.text.Line(n)
+	return renderTokens(line, e.buf.syntax.Tokens(n), e.matchesIn(line), e.currentMatchIn(e.buf, n), e.selectedIn(e.buf, n), byteOffset(line, e.colNum), uiColors["cursorLine"])
 }
 
-func (e *TextEditor) loadFile() {
-	if e.filePath == "" {
-		return
+// selectedIn returns the bytes of line n of b drawn as selected.
+func (e *TextEditor) selectedIn(b *Buffer, n int) [2]int {
+	if e.mode != VisualMode || b != e.buf {
+		return [2]int{}
 	}
+	start, end := e.selectionIn(n)
+	return [2]int{start, end}
+}
 
-	content, err := os.ReadFile(e.filePath)
-	if err != nil {
-		e.textView.SetText(fmt.Sprintf("Error loading file: %v\n\nPress 'n' for new file or 'o' to open another file.", err))
-		e.updateStatusBar(fmt.Sprintf("Error: %v", err))
+func (e *TextEditor) saveFile() {
+	if e.buf.path == "" {
+		e.showSaveDialog()
 		return
 	}
 
-	// Split content into lines
-	e.content = strings.Split(string(content), "\n")
-	if len(e.content) == 0 {
-		e.content = []string{""}
+	err := os.WriteFile(e.buf.path, []byte(e.buf.text.String()), 0644)
+	if err != nil {
+		e.updateStatusBar(fmt.Sprintf("Error saving: %v", err))
+	} else {
+		e.buf.modified = false
+		e.buf.history.MarkSaved()
+		e.addRecentFile(e.buf.path)
+		e.updateStatusBar(fmt.Sprintf("Saved: %s", filepath.Base(e.buf.path)))
 	}
+}
 
-	e.lineNum = 0
-	e.colNum = 0
-	e.showWelcome = false
-	e.modified = false
-	e.updateDisplay()
+// saveAs saves the buffer under a new name.
+func (e *TextEditor) saveAs(path string) {
+	e.buf.path = path
+	e.buf.syntax.SetHighlighter(e.highlighterFor(path, e.buf.text, e.buf.syntax.highlighter))
+	e.saveFile()
 }
 
-func (e *TextEditor) saveFile() {
-	if e.filePath == "" {
+// saveThen saves the file and runs action once the save has succeeded,
+// asking for a filename first if the buffer has none.
+func (e *TextEditor) saveThen(action func()) {
+	if e.buf.path == "" {
+		e.afterSave = action
 		e.showSaveDialog()
 		return
 	}
 
-	content := strings.Join(e.content, "\n")
-	err := os.WriteFile(e.filePath, []byte(content), 0644)
-	if err != nil {
-		e.updateStatusBar(fmt.Sprintf("Error saving: %v", err))
-	} else {
-		e.modified = false
-		e.updateStatusBar(fmt.Sprintf("Saved: %s", filepath.Base(e.filePath)))
+	e.saveFile()
+	if !e.buf.modified {
+		action()
+	}
+}
+
+// confirmDiscard runs action right away when there are no unsaved changes.
+// Otherwise it asks whether to save them, discard them or cancel the action.
+func (e *TextEditor) confirmDiscard(action func()) {
+	if !e.buf.modified {
+		action()
+		return
 	}
+
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("%s has unsaved changes.", e.getStatusText())).
+		AddButtons([]string{"Save", "Discard", "Cancel"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			e.showingDialog = false
+			e.app.SetRoot(e.getMainLayout(), true)
+
+			switch buttonLabel {
+			case "Save":
+				e.saveThen(action)
+			case "Discard":
+				action()
+			default:
+				e.updateDisplay()
+			}
+		})
+
+	e.showingDialog = true
+	e.app.SetRoot(modal, true)
 }
 
 func (e *TextEditor) showSaveDialog() {
@@ -738,18 +762,20 @@ func (e *TextEditor) showOpenDialog() {
 	e.app.SetRoot(e.openForm, true)
 }
 
+// newFile starts an Untitled buffer. An untouched empty buffer, like the one
+// behind the welcome screen, is reused rather than joined by another.
 func (e *TextEditor) newFile() {
-	e.filePath = ""
-	e.content = []string{""}
-	e.lineNum = 0
-	e.colNum = 0
-	e.showWelcome = false
-	e.modified = false
-	e.updateDisplay()
+	if e.buf != nil && e.buf.isScratch() {
+		e.switchBuffer(e.buf)
+		return
+	}
+	b := e.newBuffer("", "")
+	e.buffers = append(e.buffers, b)
+	e.switchBuffer(b)
 }
 
 func (e *TextEditor) updateStatusBar(message string) {
-	e.statusBar.SetText(fmt.Sprintf("SWIFT | %s", message))
+	e.statusBar.SetText(fmt.Sprintf("SWIFT | %s", tview.Escape(message)))
 }
 
 func (e *TextEditor) Run() error {
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"strings"
+	"time"
+)
+
+// Go highlighting
+//
+// Go files are highlighted with the standard library's own scanner instead of
+// the generic lexer, so keywords, literals and comments follow the language
+// spec exactly. On top of that the file is parsed with go/parser to find the
+// functions and types it declares, which are coloured wherever they are used.
+
+// Line states for constructs that continue on the next line
+const (
+	goStateNone = iota
+	goStateRawString
+	goStateComment
+)
+
+// How often the file is parsed again while it is being edited. An edit made
+// sooner is parsed once the interval is over, with a redraw to show it.
+const goParseInterval = 300 * time.Millisecond
+
+var goPredeclared = map[string]TokenKind{
+	"true": TokenConstant, "false": TokenConstant, "nil": TokenConstant, "iota": TokenConstant,
+
+	"any": TokenType, "bool": TokenType, "byte": TokenType, "comparable": TokenType,
+	"complex64": TokenType, "complex128": TokenType, "error": TokenType, "float32": TokenType,
+	"float64": TokenType, "int": TokenType, "int8": TokenType, "int16": TokenType,
+	"int32": TokenType, "int64": TokenType, "rune": TokenType, "string": TokenType,
+	"uint": TokenType, "uint8": TokenType, "uint16": TokenType, "uint32": TokenType,
+	"uint64": TokenType, "uintptr": TokenType,
+
+	"append": TokenBuiltin, "cap": TokenBuiltin, "clear": TokenBuiltin, "close": TokenBuiltin,
+	"complex": TokenBuiltin, "copy": TokenBuiltin, "delete": TokenBuiltin, "imag": TokenBuiltin,
+	"len": TokenBuiltin, "make": TokenBuiltin, "max": TokenBuiltin, "min": TokenBuiltin,
+	"new": TokenBuiltin, "panic": TokenBuiltin, "print": TokenBuiltin, "println": TokenBuiltin,
+	"real": TokenBuiltin, "recover": TokenBuiltin,
+}
+
+type goHighlighter struct {
+	buffer   *TextBuffer
+	declared map[string]TokenKind
+	dirty    bool
+	parsedAt time.Time
+	stop     func()       // Unregisters the buffer listener
+	queue    func(func()) // Runs a function and redraws, on the UI goroutine
+	waiting  bool         // A redraw is queued for the end of the interval
+
+	// The scanner needs a file of the line's exact size, so one is kept
+	// for each line length seen instead of adding a file for every line
+	fset    *token.FileSet
+	files   map[int]*token.File
+	scanner scanner.Scanner
+}
+
+func newGoHighlighter(buffer *TextBuffer, queue func(func())) *goHighlighter {
+	h := &goHighlighter{buffer: buffer, queue: queue, dirty: true, fset: token.NewFileSet(), files: make(map[int]*token.File)}
+	h.stop = buffer.OnChange(func(offset, removed, inserted int) {
+		h.dirty = true
+	})
+	return h
+}
+
+// Close stops following the buffer, once the highlighter is replaced.
+func (h *goHighlighter) Close() {
+	h.stop()
+}
+
+func (h *goHighlighter) Highlight(line string, state int) ([]Token, int) {
+	h.parse()
+
+	var tokens []Token
+	start := 0
+
+	// Finish a raw string or comment left open by the previous line
+	switch state {
+	case goStateRawString:
+		end := strings.IndexByte(line, '`')
+		if end < 0 {
+			return []Token{{0, len(line), TokenString}}, state
+		}
+		tokens = append(tokens, Token{0, end + 1, TokenString})
+		start = end + 1
+	case goStateComment:
+		end := strings.Index(line, "*/")
+		if end < 0 {
+			return []Token{{0, len(line), TokenComment}}, state
+		}
+		tokens = append(tokens, Token{0, end + 2, TokenComment})
+		start = end + 2
+	}
+
+	src := []byte(line[start:])
+	file, ok := h.files[len(src)]
+	if !ok {
+		file = h.fset.AddFile("", -1, len(src))
+		h.files[len(src)] = file
+	}
+	s := &h.scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		offset := file.Offset(pos)
+		from := start + offset
+
+		switch {
+		case tok.IsKeyword():
+			tokens = append(tokens, Token{from, from + len(lit), TokenKeyword})
+		case tok == token.IDENT:
+			if kind, ok := h.identKind(lit); ok {
+				tokens = append(tokens, Token{from, from + len(lit), kind})
+			}
+		case tok == token.INT, tok == token.FLOAT, tok == token.IMAG:
+			tokens = append(tokens, Token{from, from + len(lit), TokenNumber})
+		case tok == token.CHAR:
+			tokens = append(tokens, Token{from, from + len(lit), TokenString})
+		case tok == token.STRING:
+			// The scanner drops carriage returns from raw strings, so
+			// measure them in the source rather than by their literal
+			if src[offset] == '`' {
+				end := strings.IndexByte(line[from+1:], '`')
+				if end < 0 {
+					return append(tokens, Token{from, len(line), TokenString}), goStateRawString
+				}
+				tokens = append(tokens, Token{from, from + end + 2, TokenString})
+			} else {
+				tokens = append(tokens, Token{from, from + len(lit), TokenString})
+			}
+		case tok == token.COMMENT:
+			if strings.HasPrefix(line[from:], "/*") {
+				end := strings.Index(line[from+2:], "*/")
+				if end < 0 {
+					return append(tokens, Token{from, len(line), TokenComment}), goStateComment
+				}
+				tokens = append(tokens, Token{from, from + end + 4, TokenComment})
+			} else {
+				tokens = append(tokens, Token{from, len(line), TokenComment})
+			}
+		}
+	}
+
+	return tokens, goStateNone
+}
+
+func (h *goHighlighter) identKind(name string) (TokenKind, bool) {
+	if kind, ok := h.declared[name]; ok {
+		return kind, true
+	}
+	kind, ok := goPredeclared[name]
+	return kind, ok
+}
+
+// parse collects the functions and types declared in the file. The parser
+// recovers from syntax errors, so a half-typed line only loses the
+// declarations it breaks.
+func (h *goHighlighter) parse() {
+	if !h.dirty || h.waiting {
+		return
+	}
+	if wait := goParseInterval - time.Since(h.parsedAt); wait > 0 {
+		h.waiting = true
+		time.AfterFunc(wait, func() {
+			h.queue(func() { h.waiting = false })
+		})
+		return
+	}
+	h.dirty = false
+	h.parsedAt = time.Now()
+
+	file, _ := parser.ParseFile(token.NewFileSet(), "", h.buffer.String(), parser.SkipObjectResolution)
+	if file == nil {
+		return
+	}
+
+	declared := make(map[string]TokenKind)
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			declared[decl.Name.Name] = TokenFunction
+		case *ast.GenDecl:
+			if decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				declared[spec.(*ast.TypeSpec).Name.Name] = TokenType
+			}
+		}
+	}
+	h.declared = declared
+}
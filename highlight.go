@@ -0,0 +1,446 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/tview"
+)
+
+// Syntax highlighting engine
+//
+// A Highlighter splits one line into tokens. Constructs that span several
+// lines, such as block comments and raw strings, are carried from one line to
+// the next in an integer state; zero means no construct is open. Languages
+// are plain Language values that all run through the same lexer.
+
+type TokenKind int
+
+const (
+	TokenText TokenKind = iota
+	TokenKeyword
+	TokenType
+	TokenConstant
+	TokenString
+	TokenComment
+	TokenNumber
+	TokenTag
+	TokenOperator
+	TokenFunction
+	TokenBuiltin
+)
+
+var tokenKindNames = map[string]TokenKind{
+	"text":     TokenText,
+	"keyword":  TokenKeyword,
+	"type":     TokenType,
+	"constant": TokenConstant,
+	"string":   TokenString,
+	"comment":  TokenComment,
+	"number":   TokenNumber,
+	"tag":      TokenTag,
+	"operator": TokenOperator,
+	"function": TokenFunction,
+	"builtin":  TokenBuiltin,
+}
+
+// UnmarshalText reads a token kind by name, as used in syntax files.
+func (k *TokenKind) UnmarshalText(text []byte) error {
+	kind, ok := tokenKindNames[string(text)]
+	if !ok {
+		return fmt.Errorf("unknown token kind %q", text)
+	}
+	*k = kind
+	return nil
+}
+
+// Token is a highlighted span of a line, in byte offsets.
+type Token struct {
+	Start int
+	End   int
+	Kind  TokenKind
+}
+
+type Highlighter interface {
+	// Highlight tokenizes line, starting in the state left by the previous
+	// line, and returns the tokens and the state at the end of the line.
+	Highlight(line string, state int) ([]Token, int)
+}
+
+// Delimiter describes a string or block comment.
+type Delimiter struct {
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Escape    string `json:"escape"`
+	Multiline bool   `json:"multiline"`
+}
+
+// Rule highlights text matching a regular expression. A LineStart rule only
+// matches at the beginning of a line.
+type Rule struct {
+	Pattern   string    `json:"pattern"`
+	Kind      TokenKind `json:"kind"`
+	LineStart bool      `json:"lineStart"`
+}
+
+// Language is a syntax definition, loaded from a syntax file.
+type Language struct {
+	Name          string      `json:"name"`
+	Extensions    []string    `json:"extensions"`
+	Filenames     []string    `json:"filenames"`
+	Keywords      []string    `json:"keywords"`
+	Types         []string    `json:"types"`
+	Constants     []string    `json:"constants"`
+	WordChars     string      `json:"wordChars"` // characters besides letters, digits and '_' that form words
+	IgnoreNumbers bool        `json:"ignoreNumbers"`
+	LineComments  []string    `json:"lineComments"`
+	BlockComments []Delimiter `json:"blockComments"`
+	Strings       []Delimiter `json:"strings"`
+	Rules         []Rule      `json:"rules"`
+
+	compiled *lexer
+	user     bool // loaded from the user's syntax directory
+}
+
+var syntaxColors = map[TokenKind]string{
+	TokenKeyword:  "blue",
+	TokenType:     "aqua",
+	TokenConstant: "orange",
+	TokenString:   "green",
+	TokenComment:  "gray",
+	TokenNumber:   "orange",
+	TokenTag:      "red",
+	TokenOperator: "yellow",
+	TokenFunction: "yellow",
+	TokenBuiltin:  "fuchsia",
+}
+
+// span is a delimited construct as the lexer sees it.
+type span struct {
+	Delimiter
+	kind TokenKind
+}
+
+type compiledRule struct {
+	pattern   *regexp.Regexp
+	kind      TokenKind
+	lineStart bool
+}
+
+// lexer is the shared Highlighter behind every Language.
+type lexer struct {
+	words         map[string]TokenKind
+	wordChars     string
+	ignoreNumbers bool
+	lineComments  []string
+	spans         []span
+	rules         []compiledRule
+}
+
+var numberPattern = regexp.MustCompile(`^(0[xXbBoO][0-9a-fA-F_]+|[0-9][0-9_]*(\.[0-9_]*)?([eE][+-]?[0-9]+)?)[a-zA-Z%]*`)
+
+func newLexer(lang *Language) (*lexer, error) {
+	l := &lexer{
+		words:         make(map[string]TokenKind),
+		wordChars:     lang.WordChars,
+		ignoreNumbers: lang.IgnoreNumbers,
+		lineComments:  lang.LineComments,
+	}
+	for _, word := range lang.Keywords {
+		l.words[word] = TokenKeyword
+	}
+	for _, word := range lang.Types {
+		l.words[word] = TokenType
+	}
+	for _, word := range lang.Constants {
+		l.words[word] = TokenConstant
+	}
+
+	for _, delim := range lang.BlockComments {
+		delim.Multiline = true
+		l.spans = append(l.spans, span{delim, TokenComment})
+	}
+	for _, delim := range lang.Strings {
+		l.spans = append(l.spans, span{delim, TokenString})
+	}
+	for _, s := range l.spans {
+		if s.Start == "" || s.End == "" {
+			return nil, fmt.Errorf("delimiter needs both start and end")
+		}
+	}
+
+	// Try longer delimiters first so """ wins over "
+	sort.SliceStable(l.spans, func(i, j int) bool {
+		return len(l.spans[i].Start) > len(l.spans[j].Start)
+	})
+
+	for _, rule := range lang.Rules {
+		pattern, err := regexp.Compile(`^(?:` + rule.Pattern + `)`)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Pattern, err)
+		}
+		l.rules = append(l.rules, compiledRule{pattern, rule.Kind, rule.LineStart})
+	}
+	return l, nil
+}
+
+func (l *lexer) Highlight(line string, state int) ([]Token, int) {
+	var tokens []Token
+	i := 0
+
+	// Finish a construct left open by the previous line
+	if state > 0 && state <= len(l.spans) {
+		span := l.spans[state-1]
+		end, closed := spanEnd(line, 0, span.Delimiter)
+		tokens = append(tokens, Token{0, end, span.kind})
+		if !closed {
+			return tokens, state
+		}
+		i = end
+	}
+
+	for i < len(line) {
+		rest := line[i:]
+
+		if kind, n := l.matchRule(rest, i == 0); n > 0 {
+			tokens = append(tokens, Token{i, i + n, kind})
+			i += n
+			continue
+		}
+
+		if l.isLineComment(rest) {
+			tokens = append(tokens, Token{i, len(line), TokenComment})
+			return tokens, 0
+		}
+
+		if index := l.matchSpan(rest); index >= 0 {
+			span := l.spans[index]
+			end, closed := spanEnd(line, i+len(span.Start), span.Delimiter)
+			tokens = append(tokens, Token{i, end, span.kind})
+			if !closed && span.Multiline {
+				return tokens, index + 1
+			}
+			i = end
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+		switch {
+		case r >= '0' && r <= '9' && !l.ignoreNumbers:
+			n := len(numberPattern.FindString(rest))
+			tokens = append(tokens, Token{i, i + n, TokenNumber})
+			i += n
+		case l.isWordRune(r):
+			n := l.wordLen(rest)
+			if kind, ok := l.words[rest[:n]]; ok {
+				tokens = append(tokens, Token{i, i + n, kind})
+			}
+			i += n
+		default:
+			i += size
+		}
+	}
+
+	return tokens, 0
+}
+
+func (l *lexer) matchRule(text string, lineStart bool) (TokenKind, int) {
+	for _, rule := range l.rules {
+		if rule.lineStart && !lineStart {
+			continue
+		}
+		if loc := rule.pattern.FindStringIndex(text); loc != nil && loc[1] > 0 {
+			return rule.kind, loc[1]
+		}
+	}
+	return TokenText, 0
+}
+
+func (l *lexer) isLineComment(text string) bool {
+	for _, prefix := range l.lineComments {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *lexer) matchSpan(text string) int {
+	for i, span := range l.spans {
+		if strings.HasPrefix(text, span.Start) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *lexer) isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(l.wordChars, r)
+}
+
+func (l *lexer) wordLen(text string) int {
+	for i, r := range text {
+		if !l.isWordRune(r) {
+			return i
+		}
+	}
+	return len(text)
+}
+
+// spanEnd finds the closing delimiter of span in line, starting the search at
+// from. It returns the offset just past the delimiter, or the end of the line
+// and false when the span is still open.
+func spanEnd(line string, from int, span Delimiter) (int, bool) {
+	for i := from; i < len(line); {
+		if span.Escape != "" && strings.HasPrefix(line[i:], span.Escape) {
+			i += len(span.Escape)
+			if i < len(line) {
+				_, size := utf8.DecodeRuneInString(line[i:])
+				i += size
+			}
+			continue
+		}
+		if strings.HasPrefix(line[i:], span.End) {
+			return i + len(span.End), true
+		}
+		i++
+	}
+	return len(line), false
+}
+
+// syntaxCache remembers the highlighter state at the start of each line, so
+// drawing a screen only lexes from the last edited line instead of from the
+// top of the file.
+type syntaxCache struct {
+	buffer      *TextBuffer
+	highlighter Highlighter
+	states      []int // states[i] is the state at the start of line i
+}
+
+func newSyntaxCache(buffer *TextBuffer, highlighter Highlighter) *syntaxCache {
+	c := &syntaxCache{
+		buffer:      buffer,
+		highlighter: highlighter,
+		states:      []int{0},
+	}
+	buffer.OnChange(func(offset, removed, inserted int) {
+		c.invalidate(buffer.LineOf(offset))
+	})
+	return c
+}
+
+// SetHighlighter switches the language, for example after "Save as". A
+// highlighter that is replaced and has a Close method is closed.
+func (c *syntaxCache) SetHighlighter(highlighter Highlighter) {
+	if closer, ok := c.highlighter.(interface{ Close() }); ok && c.highlighter != highlighter {
+		closer.Close()
+	}
+	c.highlighter = highlighter
+	c.states = []int{0}
+}
+
+func (c *syntaxCache) invalidate(line int) {
+	if line+1 < len(c.states) {
+		c.states = c.states[:line+1]
+	}
+}
+
+// State returns the highlighter state at the start of line n, which is zero
+// unless the lines above leave a string or comment open.
+func (c *syntaxCache) State(n int) int {
+	if c.highlighter == nil {
+		return 0
+	}
+	for len(c.states) <= n {
+		last := len(c.states) - 1
+		_, state := c.highlighter.Highlight(c.buffer.Line(last), c.states[last])
+		c.states = append(c.states, state)
+	}
+	return c.states[n]
+}
+
+// Tokens returns the highlighted tokens of line n.
+func (c *syntaxCache) Tokens(n int) []Token {
+	if c.highlighter == nil {
+		return nil
+	}
+	tokens, _ := c.highlighter.Highlight(c.buffer.Line(n), c.State(n))
+	return tokens
+}
+
+// renderTokens turns a line and its tokens into tview color tags over the
+// background color bg, with the matches of a search, the current match and
+// the selected bytes in their own colours. Everything taken from the line is
+// escaped. If cursor is a byte offset within the line, a cursor indicator is
+// drawn in front of it.
+func renderTokens(line string, tokens []Token, matches [][2]int, current, selected [2]int, cursor int, bg string) string {
+	// Cut the line wherever the style changes
+	cuts := []int{0, len(line), current[0], current[1], selected[0], selected[1]}
+	for _, token := range tokens {
+		cuts = append(cuts, token.Start, token.End)
+	}
+	for _, match := range matches {
+		cuts = append(cuts, match[0], match[1])
+	}
+	if cursor >= 0 {
+		cursor = min(cursor, len(line))
+		cuts = append(cuts, cursor)
+	}
+	sort.Ints(cuts)
+
+	var result strings.Builder
+	drawn := cursor < 0
+	for i, start := range cuts[:len(cuts)-1] {
+		end := cuts[i+1]
+		if start == end {
+			continue
+		}
+
+		color, back := "-", bg
+		for _, token := range tokens {
+			if token.Start <= start && start < token.End && syntaxColors[token.Kind] != "" {
+				color = syntaxColors[token.Kind]
+			}
+		}
+		for _, match := range matches {
+			if match[0] <= start && start < match[1] {
+				fg, matchBack, found := strings.Cut(uiColors["match"], ":")
+				color = fg
+				if found {
+					back = matchBack
+				}
+			}
+		}
+		if current[0] <= start && start < current[1] {
+			fg, currentBack, found := strings.Cut(uiColors["currentMatch"], ":")
+			color = fg
+			if found {
+				back = currentBack
+			}
+		}
+		if selected[0] <= start && start < selected[1] {
+			fg, selectBack, found := strings.Cut(uiColors["selection"], ":")
+			color = fg
+			if found {
+				back = selectBack
+			}
+		}
+
+		if !drawn && start == cursor {
+			result.WriteString("[" + uiColors["cursor"] + "]▌")
+			drawn = true
+		}
+		result.WriteString("[" + color + ":" + back + "]")
+		result.WriteString(tview.Escape(line[start:end]))
+	}
+	if !drawn {
+		result.WriteString("[" + uiColors["cursor"] + "]▌")
+	}
+
+	result.WriteString("[-:-]")
+	return result.String()
+}
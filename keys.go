@@ -0,0 +1,480 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Key bindings
+//
+// Keys are named like "Ctrl+R", "Alt+w", "Shift+Tab", "Up" or "n", and a
+// sequence of keys is their names separated by spaces, like "g g". Each mode
+// has a keymap from key sequences to the registry's commands. The defaults
+// come from the registry and the "keys" section of the config file changes
+// them:
+//
+//	"keys": {
+//	  "view": {"Ctrl+S": "write", "g t": "bnext", "u": "none"},
+//	  "edit": {"Ctrl+S": "write", "Ctrl+Q": "quit!"}
+//	}
+//
+// A binding is a command name with an optional '!' and argument, or "none"
+// to remove a default binding. Keys in "operator" apply in View Mode while an
+// operator like d waits for its motion, over those of "view". Visual Mode
+// has the keys of both, with those of "visual" over them.
+
+// binding is what a key sequence runs.
+type binding struct {
+	cmd  *Command
+	bang bool
+	arg  string
+}
+
+type keymap map[string]*binding
+
+const (
+	defaultKeyTimeout = 1000 // Milliseconds
+	maxCount          = 99999
+)
+
+var modeNames = map[string]EditorMode{
+	"view":     ViewMode,
+	"edit":     EditMode,
+	"visual":   VisualMode,
+	"operator": OperatorMode,
+}
+
+// keyName returns the name of the key pressed in event, or "" if it has none.
+func keyName(event *tcell.EventKey) string {
+	mods := event.Modifiers()
+	var name string
+	switch event.Key() {
+	case tcell.KeyRune:
+		// Shift is already part of the character
+		name = string(event.Rune())
+		if event.Rune() == ' ' {
+			name = "Space"
+		}
+		mods &^= tcell.ModShift
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		name = "Backspace"
+	case tcell.KeyBacktab:
+		name = "Tab"
+		mods |= tcell.ModShift
+	default:
+		name = tcell.KeyNames[event.Key()]
+		if rest, ok := strings.CutPrefix(name, "Ctrl-"); ok {
+			name = rest
+			mods |= tcell.ModCtrl
+		}
+	}
+	if name == "" {
+		return ""
+	}
+	return modifierPrefix(mods) + name
+}
+
+func modifierPrefix(mods tcell.ModMask) string {
+	prefix := ""
+	if mods&tcell.ModCtrl != 0 {
+		prefix += "Ctrl+"
+	}
+	if mods&tcell.ModAlt != 0 {
+		prefix += "Alt+"
+	}
+	if mods&tcell.ModShift != 0 {
+		prefix += "Shift+"
+	}
+	return prefix
+}
+
+// normalizeKey turns a key name from the config file into the name keyName
+// gives the same key, so "ctrl+s" becomes "Ctrl+S" and "Shift+a" becomes "A".
+func normalizeKey(name string) (string, error) {
+	var mods tcell.ModMask
+	rest := name
+	for {
+		modifier, after, found := strings.Cut(rest, "+")
+		if !found || after == "" {
+			break
+		}
+		switch strings.ToLower(modifier) {
+		case "ctrl":
+			mods |= tcell.ModCtrl
+		case "alt":
+			mods |= tcell.ModAlt
+		case "shift":
+			mods |= tcell.ModShift
+		default:
+			return "", fmt.Errorf("unknown modifier %q in %q", modifier, name)
+		}
+		rest = after
+	}
+
+	if r, size := utf8.DecodeRuneInString(rest); size == len(rest) && r != utf8.RuneError {
+		switch {
+		case mods&tcell.ModCtrl != 0 && r < utf8.RuneSelf && unicode.IsLetter(r):
+			// Terminals can't tell Ctrl+s from Ctrl+S
+			r = unicode.ToUpper(r)
+			mods &^= tcell.ModShift
+		case mods&tcell.ModShift != 0:
+			r = unicode.ToUpper(r)
+			mods &^= tcell.ModShift
+		}
+		return modifierPrefix(mods) + string(r), nil
+	}
+
+	for _, known := range namedKeys() {
+		if strings.EqualFold(rest, known) {
+			return modifierPrefix(mods) + known, nil
+		}
+	}
+	return "", fmt.Errorf("unknown key %q", name)
+}
+
+// namedKeys lists the names of keys that aren't characters.
+func namedKeys() []string {
+	names := []string{"Space", "Backspace"}
+	for key, name := range tcell.KeyNames {
+		// keyName calls these Backspace and Shift+Tab
+		if key != tcell.KeyBackspace2 && key != tcell.KeyBacktab && !strings.HasPrefix(name, "Ctrl-") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseBinding reads a binding like "write", "quit!" or "tab 2".
+func parseBinding(value string) (*binding, error) {
+	name, bang, rest := splitName(strings.TrimSpace(value))
+	def := findCommand(name)
+	if def == nil {
+		return nil, fmt.Errorf("unknown command %q", value)
+	}
+	c := &exCommand{name: name, bang: bang, arg: strings.TrimSpace(rest)}
+	if err := c.check(def); err != nil {
+		return nil, fmt.Errorf("%s: %v", value, err)
+	}
+	return &binding{cmd: def, bang: c.bang, arg: c.arg}, nil
+}
+
+// parseKeys checks the "keys" section of the config file and returns the
+// keymaps it describes, with normalized key names and nil for "none".
+func parseKeys(keys map[string]map[string]string) (map[EditorMode]keymap, error) {
+	keymaps := make(map[EditorMode]keymap)
+	for modeName, bindings := range keys {
+		mode, ok := modeNames[modeName]
+		if !ok {
+			return nil, fmt.Errorf("keys: unknown mode %q, expected \"view\", \"edit\", \"visual\" or \"operator\"", modeName)
+		}
+		keymaps[mode] = make(keymap)
+		for sequence, value := range bindings {
+			var names []string
+			for _, key := range strings.Fields(sequence) {
+				name, err := normalizeKey(key)
+				if err != nil {
+					return nil, fmt.Errorf("keys.%s: %v", modeName, err)
+				}
+				names = append(names, name)
+			}
+			if len(names) == 0 {
+				return nil, fmt.Errorf("keys.%s: empty key", modeName)
+			}
+
+			var b *binding
+			if value != "none" {
+				var err error
+				if b, err = parseBinding(value); err != nil {
+					return nil, fmt.Errorf("keys.%s.%s: %v", modeName, sequence, err)
+				}
+			}
+			keymaps[mode][strings.Join(names, " ")] = b
+		}
+	}
+	return keymaps, nil
+}
+
+// bindingsFor returns the registry's default bindings with the overrides of
+// the config file applied.
+func bindingsFor(config *Config) map[EditorMode]keymap {
+	bindings := map[EditorMode]keymap{
+		ViewMode:     {},
+		EditMode:     {},
+		VisualMode:   {},
+		OperatorMode: {},
+	}
+	for _, def := range commands {
+		for _, key := range def.keys {
+			bindings[ViewMode][key] = &binding{cmd: def}
+		}
+		for _, key := range def.editKeys {
+			bindings[EditMode][key] = &binding{cmd: def}
+		}
+		for _, key := range def.visualKeys {
+			bindings[VisualMode][key] = &binding{cmd: def}
+		}
+		for _, key := range def.opKeys {
+			bindings[OperatorMode][key] = &binding{cmd: def}
+		}
+	}
+
+	// The config was checked when it was loaded
+	overrides, _ := parseKeys(config.Keys)
+	for mode, keys := range overrides {
+		for key, b := range keys {
+			if b == nil {
+				delete(bindings[mode], key)
+			} else {
+				bindings[mode][key] = b
+			}
+		}
+	}
+	return bindings
+}
+
+// keysFor returns the keys bound to a command in a mode.
+func (e *TextEditor) keysFor(mode EditorMode, def *Command) []string {
+	var keys []string
+	for key, b := range e.bindings[mode] {
+		if b.cmd == def {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// keymap returns the keys that work in a mode right now: those of View Mode
+// with the operator keys over them while an operator waits, and the Visual
+// Mode keys over both while selecting.
+func (e *TextEditor) keymap(mode EditorMode) keymap {
+	switch {
+	case mode == VisualMode:
+		return overlay(overlay(e.bindings[ViewMode], e.bindings[OperatorMode]), e.bindings[VisualMode])
+	case mode == ViewMode && e.operator != nil:
+		return overlay(e.bindings[ViewMode], e.bindings[OperatorMode])
+	}
+	return e.bindings[mode]
+}
+
+// overlay returns the keys of base with those of over replacing them. A key
+// of over also hides the sequences of base it starts, so the > of Visual
+// Mode doesn't wait for the "> >" of View Mode.
+func overlay(base, over keymap) keymap {
+	keys := make(keymap)
+	for key, b := range base {
+		first, _, _ := strings.Cut(key, " ")
+		if _, hidden := over[first]; !hidden || first == key {
+			keys[key] = b
+		}
+	}
+	maps.Copy(keys, over)
+	return keys
+}
+
+// dispatchKey runs the binding that the pending keys and this one complete,
+// or keeps the keys pending while they start a longer sequence. It reports
+// whether the key was used. When the key breaks off a sequence, the pending
+// keys are typed in Edit Mode and dropped in View Mode, and the key is tried
+// on its own.
+//
+// In View and Visual Mode, digits typed before a sequence are its count, as
+// in "5j". Esc drops a count or pending keys.
+func (e *TextEditor) dispatchKey(mode EditorMode, event *tcell.EventKey) bool {
+	key := keyName(event)
+	if key == "" {
+		return false
+	}
+
+	if mode != EditMode && len(key) == 1 && key[0] >= '0' && key[0] <= '9' && (key != "0" || e.count > 0) {
+		e.count = min(e.count*10+int(key[0]-'0'), maxCount)
+		e.setPendingKeys(e.pendingKeys, mode)
+		e.updateDisplay()
+		return true
+	}
+	if key == "Esc" && (e.pendingKeys != "" || e.count > 0 || e.operator != nil || e.regName != 0) {
+		e.count = 0
+		e.operator = nil
+		e.regName = 0
+		e.setPendingKeys("", mode)
+		e.updateDisplay()
+		return true
+	}
+
+	pending := e.pendingKeys
+	sequence := strings.TrimPrefix(pending+" "+key, " ")
+	e.setPendingKeys("", mode)
+
+	// A sequence that starts a longer one waits for the next key, and runs
+	// when none comes in time
+	keys := e.keymap(mode)
+	for bound := range keys {
+		if strings.HasPrefix(bound, sequence+" ") {
+			e.setPendingKeys(sequence, mode)
+			return true
+		}
+	}
+	if b := keys[sequence]; b != nil {
+		e.runKeys(b, sequence)
+		return true
+	}
+
+	if pending == "" {
+		if e.count > 0 || e.operator != nil || e.regName != 0 {
+			e.count = 0
+			e.operator = nil
+			e.regName = 0
+			e.updateDisplay()
+		}
+		return false
+	}
+	if mode == EditMode {
+		e.typeKeys(pending)
+	}
+	return e.dispatchKey(mode, event)
+}
+
+// setPendingKeys keeps keys waiting for the rest of their sequence, and
+// starts the timeout after which they give up waiting.
+func (e *TextEditor) setPendingKeys(keys string, mode EditorMode) {
+	changed := keys != e.pendingKeys
+	e.pendingKeys = keys
+	e.keyTimer++
+	if keys != "" {
+		timer := e.keyTimer
+		time.AfterFunc(e.keyTimeout(), func() {
+			e.app.QueueUpdateDraw(func() {
+				if timer == e.keyTimer {
+					e.expireKeys(mode)
+				}
+			})
+		})
+	}
+	if changed {
+		e.updateDisplay()
+	}
+}
+
+// expireKeys runs or drops the pending keys once no key has followed them
+// in time.
+func (e *TextEditor) expireKeys(mode EditorMode) {
+	sequence := e.pendingKeys
+	e.setPendingKeys("", mode)
+	if b := e.keymap(mode)[sequence]; b != nil {
+		e.runKeys(b, sequence)
+		return
+	}
+	e.count = 0
+	e.operator = nil
+	e.regName = 0
+	e.updateDisplay()
+	if mode == EditMode {
+		e.typeKeys(sequence)
+	}
+}
+
+// typeKeys inserts the characters of a key sequence that turned out not to
+// be bound.
+func (e *TextEditor) typeKeys(sequence string) {
+	for _, key := range strings.Fields(sequence) {
+		if key == "Space" {
+			key = " "
+		}
+		if r, size := utf8.DecodeRuneInString(key); size == len(key) {
+			e.insertChar(r)
+		}
+	}
+}
+
+// runKeys runs the binding of a key sequence with the count typed before it.
+func (e *TextEditor) runKeys(b *binding, sequence string) {
+	count := e.count
+	e.count = 0
+	e.runCommand(b, sequence, count)
+}
+
+func (e *TextEditor) keyTimeout() time.Duration {
+	timeout := defaultKeyTimeout
+	if e.config.KeyTimeout != nil {
+		timeout = *e.config.KeyTimeout
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+// pendingText shows the register, operator, count and keys typed so far,
+// like "d3" or "\"a2dg".
+func (e *TextEditor) pendingText() string {
+	text := e.operatorText(e.operator) + e.awaiting
+	if e.regName != 0 {
+		text = "\"" + string(e.regName) + text
+	}
+	if e.count > 0 {
+		text += fmt.Sprint(e.count)
+	}
+	return text + e.pendingKeys
+}
+
+// runCommand runs a command on the current line, for a key or a pick from
+// the palette. key is the key sequence that ran it, if any, and count the
+// count typed before it, or 0. A count on a command taking a range makes it
+// work on that many lines. A waiting operator is dropped by a command that
+// isn't a motion, and a picked register by one that doesn't use it.
+func (e *TextEditor) runCommand(b *binding, key string, count int) {
+	op, reg := e.operator, e.regName
+	cmd := &exCommand{start: e.lineNum, end: e.lineNum, name: b.cmd.name, bang: b.bang, arg: b.arg, key: key, count: count}
+	if count > 0 && b.cmd.rng {
+		cmd.end = min(e.lineNum+count-1, e.lastLine())
+	}
+	err := b.cmd.run(e, cmd)
+	if op != nil && e.operator == op || reg != 0 && e.regName == reg {
+		e.operator = nil
+		e.regName = 0
+		e.updateDisplay()
+	}
+	if err != nil {
+		e.updateStatusBar(fmt.Sprintf("Error: %v", err))
+	}
+}
+
+// showBindings lists the active key bindings of both modes.
+func (e *TextEditor) showBindings() {
+	var text strings.Builder
+	titles := map[EditorMode]string{
+		ViewMode:     "View Mode",
+		EditMode:     "Edit Mode",
+		VisualMode:   "Visual Mode",
+		OperatorMode: "After an Operator",
+	}
+	for _, mode := range []EditorMode{ViewMode, EditMode, VisualMode, OperatorMode} {
+		if mode != ViewMode {
+			text.WriteString("\n")
+		}
+		text.WriteString("[yellow]" + titles[mode] + "[-]\n")
+
+		var keys []string
+		for key := range e.bindings[mode] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			b := e.bindings[mode][key]
+			command := b.cmd.name
+			if b.bang {
+				command += "!"
+			}
+			if b.arg != "" {
+				command += " " + b.arg
+			}
+			text.WriteString(tview.Escape(fmt.Sprintf("  %-16s %-20s %s\n", key, command, b.cmd.desc)))
+		}
+	}
+	e.showText(" Key Bindings - Esc to close ", text.String())
+}
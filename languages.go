@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Syntax definition files
+//
+// Every language is described by a JSON file. The built-in ones are compiled
+// into the binary from the syntax directory; files in the "syntax" folder of
+// the config directory are loaded after them and replace a built-in language
+// with the same name. Go is highlighted by the built-in go/scanner highlighter
+// unless a user file defines it.
+
+//go:embed syntax/*.json
+var builtinSyntax embed.FS
+
+// loadLanguages reads the built-in and user syntax files. A file that fails to
+// load is reported and skipped, so one broken definition doesn't disable
+// highlighting for the others.
+func loadLanguages(userDir string) ([]*Language, []error) {
+	var languages []*Language
+	var errs []error
+
+	add := func(name string, data []byte, user bool) {
+		lang, err := parseLanguage(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		lang.user = user
+		for i, existing := range languages {
+			if strings.EqualFold(existing.Name, lang.Name) {
+				languages[i] = lang
+				return
+			}
+		}
+		languages = append(languages, lang)
+	}
+
+	builtin, _ := fs.Glob(builtinSyntax, "syntax/*.json")
+	for _, name := range builtin {
+		data, err := builtinSyntax.ReadFile(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		add(name, data, false)
+	}
+
+	if userDir != "" {
+		files, _ := filepath.Glob(filepath.Join(userDir, "*.json"))
+		for _, name := range files {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			add(name, data, true)
+		}
+	}
+
+	return languages, errs
+}
+
+func parseLanguage(data []byte) (*Language, error) {
+	var lang Language
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&lang); err != nil {
+		return nil, err
+	}
+	if lang.Name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+	if len(lang.Extensions) == 0 && len(lang.Filenames) == 0 {
+		return nil, fmt.Errorf("%s: no extensions or filenames", lang.Name)
+	}
+
+	compiled, err := newLexer(&lang)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", lang.Name, err)
+	}
+	lang.compiled = compiled
+	return &lang, nil
+}
+
+// languageFor picks the language for a file by its name or extension.
+func languageFor(languages []*Language, path string) *Language {
+	if path == "" {
+		return nil
+	}
+	base := filepath.Base(path)
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, lang := range languages {
+		for _, name := range lang.Filenames {
+			if name == base {
+				return lang
+			}
+		}
+	}
+	for _, lang := range languages {
+		for _, candidate := range lang.Extensions {
+			if strings.ToLower(candidate) == ext {
+				return lang
+			}
+		}
+	}
+	return nil
+}
+
+// highlighterFor returns the highlighter for buffer saved at path, or nil if
+// no language matches it. Go files get the go/scanner based highlighter unless
+// the user has their own Go syntax file. The Go highlighter parses the whole
+// buffer, so current is kept when it already is one for buffer.
+func (e *TextEditor) highlighterFor(path string, buffer *TextBuffer, current Highlighter) Highlighter {
+	lang := languageFor(e.languages, path)
+	if strings.ToLower(filepath.Ext(path)) == ".go" && (lang == nil || !lang.user) {
+		if h, ok := current.(*goHighlighter); ok && h.buffer == buffer {
+			return h
+		}
+		return newGoHighlighter(buffer, func(f func()) { e.app.QueueUpdateDraw(f) })
+	}
+
+	if lang == nil {
+		return nil
+	}
+	return lang.compiled
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"log"
-	"os"
 )
 
 // SWIFT - Streamlined Workflow, Increased Focus Typography
@@ -11,15 +10,23 @@ import (
 
 func main() {
 	var filePath string
+	var scrollOff int
 	flag.StringVar(&filePath, "f", "", "File to edit")
+	flag.IntVar(&scrollOff, "scrolloff", defaultScrollOff, "Lines kept visible above and below the cursor, over the config file")
 	flag.Parse()
 
-	// If no file specified via flag, check first argument
-	if filePath == "" && len(os.Args) > 1 {
-		filePath = os.Args[1]
+	// Every argument is opened in its own buffer
+	filePaths := flag.Args()
+	if filePath != "" {
+		filePaths = append([]string{filePath}, filePaths...)
 	}
 
-	editor := NewTextEditor(filePath)
+	editor := NewTextEditor(filePaths)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "scrolloff" {
+			editor.SetScrollOff(scrollOff)
+		}
+	})
 	if err := editor.Run(); err != nil {
 		log.Fatal(err)
 	}
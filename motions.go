@@ -0,0 +1,520 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Motions and operators
+//
+// In View Mode a motion moves the cursor: w, b and e by words, 0, ^ and $
+// within the line, gg and G to the first and last line, { and } by
+// paragraphs, and f, t, F and T to a character in the line. Typed after an
+// operator, d (delete), c (change) or y (yank), the motion picks the text the
+// operator works on instead, so "dw" deletes a word and "y$" yanks the rest
+// of the line. An operator typed twice works on whole lines, as in "dd" and
+// "3yy". Counts go before the operator, the motion or both: "2d3w" deletes
+// six words.
+
+type motionKind int
+
+const (
+	exclusive motionKind = iota // Up to the target, not including it
+	inclusive                   // Up to and including the target
+	linewise                    // Whole lines from the cursor's to the target's
+)
+
+// motion returns where a motion moves the cursor to from the cursor
+// position, or false if it can't move. count is 0 if none was typed.
+type motion func(e *TextEditor, count int) (cursorPos, motionKind, bool)
+
+// operator is an operator waiting for its motion.
+type operator struct {
+	name  string // "delete", "change" or "yank"
+	key   string
+	count int
+	reg   rune // Register picked for it, 0 if none
+}
+
+// charClass sorts runes into blanks (0), punctuation (1) and word
+// characters (2). A word is a run of one class other than blanks.
+func charClass(r rune) int {
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return 2
+	}
+	return 1
+}
+
+// classAt returns the class of the rune at p. The end of a line counts as
+// blank.
+func (e *TextEditor) classAt(p cursorPos) int {
+	runes := []rune(e.buf.text.Line(p.line))
+	if p.col >= len(runes) {
+		return 0
+	}
+	return charClass(runes[p.col])
+}
+
+func (e *TextEditor) isEmptyLine(n int) bool {
+	return e.buf.text.Line(n) == ""
+}
+
+// nextPos returns the position after p, going on to the next line after the
+// end of a line.
+func (e *TextEditor) nextPos(p cursorPos) (cursorPos, bool) {
+	switch {
+	case p.col < runeLen(e.buf.text.Line(p.line)):
+		return cursorPos{p.line, p.col + 1}, true
+	case p.line < e.lastLine():
+		return cursorPos{p.line + 1, 0}, true
+	}
+	return p, false
+}
+
+// prevPos returns the position before p, going back to the end of the
+// previous line from the start of a line.
+func (e *TextEditor) prevPos(p cursorPos) (cursorPos, bool) {
+	switch {
+	case p.col > 0:
+		return cursorPos{p.line, p.col - 1}, true
+	case p.line > 0:
+		return cursorPos{p.line - 1, runeLen(e.buf.text.Line(p.line - 1))}, true
+	}
+	return p, false
+}
+
+// nextWordStart returns the start of the word after p. Empty lines count as
+// words.
+func (e *TextEditor) nextWordStart(p cursorPos) cursorPos {
+	start := p
+	if class := e.classAt(p); class != 0 {
+		for e.classAt(p) == class {
+			next, ok := e.nextPos(p)
+			if !ok {
+				return p
+			}
+			p = next
+		}
+	}
+	for e.classAt(p) == 0 {
+		if e.isEmptyLine(p.line) && p != start {
+			return p
+		}
+		next, ok := e.nextPos(p)
+		if !ok {
+			return p
+		}
+		p = next
+	}
+	return p
+}
+
+// wordEnd returns the end of the word at p, or of the next one if p is
+// already at the end of its word.
+func (e *TextEditor) wordEnd(p cursorPos) cursorPos {
+	next, ok := e.nextPos(p)
+	if !ok {
+		return p
+	}
+	p = next
+	for e.classAt(p) == 0 {
+		if next, ok = e.nextPos(p); !ok {
+			return p
+		}
+		p = next
+	}
+	return e.currentWordEnd(p)
+}
+
+// currentWordEnd returns the last position of the word at p.
+func (e *TextEditor) currentWordEnd(p cursorPos) cursorPos {
+	class := e.classAt(p)
+	for {
+		next, ok := e.nextPos(p)
+		if !ok || next.line != p.line || e.classAt(next) != class {
+			return p
+		}
+		p = next
+	}
+}
+
+// prevWordStart returns the start of the word before p, or of the word p is
+// in if p isn't at its start.
+func (e *TextEditor) prevWordStart(p cursorPos) cursorPos {
+	prev, ok := e.prevPos(p)
+	if !ok {
+		return p
+	}
+	p = prev
+	for e.classAt(p) == 0 {
+		if e.isEmptyLine(p.line) {
+			return p
+		}
+		if prev, ok = e.prevPos(p); !ok {
+			return p
+		}
+		p = prev
+	}
+	class := e.classAt(p)
+	for {
+		prev, ok := e.prevPos(p)
+		if !ok || prev.line != p.line || e.classAt(prev) != class {
+			return p
+		}
+		p = prev
+	}
+}
+
+// firstNonBlank returns the column of the first non-blank rune of line n.
+func (e *TextEditor) firstNonBlank(n int) int {
+	for col, r := range []rune(e.buf.text.Line(n)) {
+		if !unicode.IsSpace(r) {
+			return col
+		}
+	}
+	return runeLen(e.buf.text.Line(n))
+}
+
+func (e *TextEditor) cursor() cursorPos {
+	return cursorPos{e.lineNum, e.colNum}
+}
+
+// Motions
+
+func wordForward(e *TextEditor, count int) (cursorPos, motionKind, bool) {
+	p := e.cursor()
+
+	// "cw" on a word changes to its end, like "ce"
+	if e.operator != nil && e.operator.name == "change" && e.classAt(p) != 0 {
+		p = e.currentWordEnd(p)
+		for i := 1; i < max(count, 1); i++ {
+			p = e.wordEnd(p)
+		}
+		return p, inclusive, true
+	}
+
+	for i := 0; i < max(count, 1); i++ {
+		next := e.nextWordStart(p)
+		// An operator stops at the end of the line of the last word
+		if e.operator != nil && i == max(count, 1)-1 && next.line > p.line {
+			return cursorPos{p.line, runeLen(e.buf.text.Line(p.line))}, exclusive, true
+		}
+		p = next
+	}
+	return p, exclusive, true
+}
+
+func wordBackward(e *TextEditor, count int) (cursorPos, motionKind, bool) {
+	p := e.cursor()
+	for i := 0; i < max(count, 1); i++ {
+		p = e.prevWordStart(p)
+	}
+	return p, exclusive, true
+}
+
+func wordForwardEnd(e *TextEditor, count int) (cursorPos, motionKind, bool) {
+	p := e.cursor()
+	for i := 0; i < max(count, 1); i++ {
+		p = e.wordEnd(p)
+	}
+	return p, inclusive, true
+}
+
+func lineFirstChar(e *TextEditor, count int) (cursorPos, motionKind, bool) {
+	return cursorPos{e.lineNum, e.firstNonBlank(e.lineNum)}, exclusive, true
+}
+
+// lineEnd goes to the end of the line, or of the line count-1 lines down.
+func lineEnd(e *TextEditor, count int) (cursorPos, motionKind, bool) {
+	n := min(e.lineNum+max(count, 1)-1, e.lastLine())
+	return cursorPos{n, runeLen(e.buf.text.Line(n))}, exclusive, true
+}
+
+// fileTop goes to the first line, or to line count.
+func fileTop(e *TextEditor, count int) (cursorPos, motionKind, bool) {
+	n := min(max(count, 1), e.lastLine()+1) - 1
+	return cursorPos{n, e.firstNonBlank(n)}, linewise, true
+}
+
+// fileBottom goes to the last line, or to line count.
+func fileBottom(e *TextEditor, count int) (cursorPos, motionKind, bool) {
+	n := e.lastLine()
+	if count > 0 {
+		n = min(count, e.lastLine()+1) - 1
+	}
+	return cursorPos{n, e.firstNonBlank(n)}, linewise, true
+}
+
+// paragraphForward goes to the empty line after the paragraph, or the end
+// of the buffer.
+func paragraphForward(e *TextEditor, count int) (cursorPos, motionKind, bool) {
+	n, last := e.lineNum, e.lastLine()
+	for i := 0; i < max(count, 1); i++ {
+		for n < last && e.isEmptyLine(n) {
+			n++
+		}
+		for n < last && !e.isEmptyLine(n) {
+			n++
+		}
+	}
+	if !e.isEmptyLine(n) {
+		return cursorPos{n, runeLen(e.buf.text.Line(n))}, exclusive, true
+	}
+	return cursorPos{n, 0}, exclusive, true
+}
+
+// paragraphBackward goes to the empty line before the paragraph, or the
+// start of the buffer.
+func paragraphBackward(e *TextEditor, count int) (cursorPos, motionKind, bool) {
+	n := e.lineNum
+	for i := 0; i < max(count, 1); i++ {
+		for n > 0 && e.isEmptyLine(n) {
+			n--
+		}
+		for n > 0 && !e.isEmptyLine(n) {
+			n--
+		}
+	}
+	return cursorPos{n, 0}, exclusive, true
+}
+
+// findChar returns the motion to the count-th r in the line, forward or
+// backward. till stops next to it.
+func findChar(r rune, forward, till bool) motion {
+	return func(e *TextEditor, count int) (cursorPos, motionKind, bool) {
+		runes := []rune(e.buf.text.Line(e.lineNum))
+		col, found := e.colNum, 0
+		for found < max(count, 1) {
+			if forward {
+				col++
+			} else {
+				col--
+			}
+			if col < 0 || col >= len(runes) {
+				return e.cursor(), exclusive, false
+			}
+			if runes[col] == r {
+				found++
+			}
+		}
+		switch {
+		case forward && till:
+			return cursorPos{e.lineNum, col - 1}, inclusive, true
+		case forward:
+			return cursorPos{e.lineNum, col}, inclusive, true
+		case till:
+			return cursorPos{e.lineNum, col + 1}, exclusive, true
+		}
+		return cursorPos{e.lineNum, col}, exclusive, true
+	}
+}
+
+// moved turns a moveX function into a motion by moving the cursor and
+// putting it back.
+func moved(move func(e *TextEditor), kind motionKind) motion {
+	return func(e *TextEditor, count int) (cursorPos, motionKind, bool) {
+		line, col, top := e.lineNum, e.colNum, e.topLine
+		for i := 0; i < max(count, 1); i++ {
+			move(e)
+		}
+		target := e.cursor()
+		e.lineNum, e.colNum, e.topLine = line, col, top
+		return target, kind, true
+	}
+}
+
+// Running motions and operators
+
+// motionCount combines the counts typed before the operator and the motion.
+func motionCount(op *operator, count int) int {
+	if op == nil || op.count == 0 {
+		return count
+	}
+	return op.count * max(count, 1)
+}
+
+// moveBy runs a motion: it moves the cursor, or hands the text it covers to
+// the waiting operator.
+func (e *TextEditor) moveBy(m motion, c *exCommand) {
+	op := e.operator
+	target, kind, ok := m(e, motionCount(op, c.count))
+	e.operator = nil
+	if !ok {
+		e.updateDisplay()
+		return
+	}
+	if op == nil {
+		e.buf.history.Close()
+		e.setCursor(target)
+		e.updateDisplay()
+		return
+	}
+	e.applyOperator(op, e.cursor(), target, kind)
+}
+
+// startOperator waits for the motion of an operator, or runs it on whole
+// lines when it is typed twice. In Visual Mode it runs on the selection.
+func (e *TextEditor) startOperator(name string, c *exCommand) {
+	if e.mode == VisualMode {
+		e.visualOperate(name)
+		return
+	}
+	op := e.operator
+	e.operator = nil
+	switch {
+	case op == nil:
+		e.operator = &operator{name: name, key: c.key, count: c.count, reg: e.regName}
+		e.regName = 0
+		e.updateDisplay()
+	case op.name == name:
+		last := min(e.lineNum+max(motionCount(op, c.count), 1)-1, e.lastLine())
+		e.applyOperator(op, e.cursor(), cursorPos{last, 0}, linewise)
+	default:
+		e.updateDisplay()
+	}
+}
+
+// awaitChar reads the character of f, t, F and T, then runs the motion.
+func (e *TextEditor) awaitChar(c *exCommand, forward, till bool) {
+	e.awaitRune(c, func(op *operator, r rune) {
+		e.operator = op
+		e.moveBy(findChar(r, forward, till), c)
+	})
+}
+
+// awaitRune reads the character a command needs, like the x of "dfx", and
+// hands it to then with the operator waiting before the command. Any other
+// key cancels.
+func (e *TextEditor) awaitRune(c *exCommand, then func(op *operator, r rune)) {
+	op := e.operator
+	e.operator = nil
+	e.awaiting = e.operatorText(op) + c.key
+	e.updateDisplay()
+
+	e.capture = func(event *tcell.EventKey) *tcell.EventKey {
+		e.capture = nil
+		e.awaiting = ""
+		if event.Key() != tcell.KeyRune {
+			e.updateDisplay()
+			return nil
+		}
+		then(op, event.Rune())
+		return nil
+	}
+}
+
+func (e *TextEditor) operatorText(op *operator) string {
+	if op == nil {
+		return ""
+	}
+	text := op.key
+	if op.count > 0 {
+		text = fmt.Sprintf("%d%s", op.count, op.key)
+	}
+	if op.reg != 0 {
+		text = "\"" + string(op.reg) + text
+	}
+	return text
+}
+
+// applyOperator deletes, changes or yanks the text a motion went over.
+func (e *TextEditor) applyOperator(op *operator, from, to cursorPos, kind motionKind) {
+	if to.line < from.line || to.line == from.line && to.col < from.col {
+		from, to = to, from
+	}
+
+	// An exclusive motion that ends at the start of a line stops at the end
+	// of the line before, and takes whole lines if it started before the
+	// text of its line, as "d}" does at the start of a paragraph
+	if kind == exclusive && to.line > from.line && to.col == 0 {
+		to = cursorPos{to.line - 1, runeLen(e.buf.text.Line(to.line - 1))}
+		if from.col <= e.firstNonBlank(from.line) {
+			kind = linewise
+		}
+	}
+	e.operate(op, from, to, kind)
+}
+
+// operate deletes, changes or yanks the text between from and to.
+func (e *TextEditor) operate(op *operator, from, to cursorPos, kind motionKind) {
+	if e.showWelcome {
+		return
+	}
+	e.buf.history.Close()
+	if kind == linewise {
+		e.applyLinewise(op, from.line, to.line)
+		return
+	}
+
+	start := e.buf.text.LineStart(from.line) + byteOffset(e.buf.text.Line(from.line), from.col)
+	end := e.buf.text.LineStart(to.line) + byteOffset(e.buf.text.Line(to.line), to.col)
+	if kind == inclusive {
+		line := e.buf.text.Line(to.line)
+		if to.col < runeLen(line) {
+			end = e.buf.text.LineStart(to.line) + byteOffset(line, nextGraphemeCol(line, to.col))
+		} else if to.line < e.lastLine() {
+			end++
+		}
+	}
+	e.storeRegister(op, register{text: e.buf.text.Slice(start, end)})
+
+	switch op.name {
+	case "yank":
+		e.setCursor(from)
+		e.updateDisplay()
+	case "delete":
+		e.deleteText(start, end-start)
+		e.setCursor(from)
+		e.editDone()
+		e.buf.history.Close()
+	case "change":
+		e.deleteText(start, end-start)
+		e.setCursor(from)
+		e.editDone()
+		e.enterEditMode()
+	}
+}
+
+// applyLinewise deletes, changes or yanks lines first to last. Changing
+// lines leaves one empty line to type in.
+func (e *TextEditor) applyLinewise(op *operator, first, last int) {
+	start := e.buf.text.LineStart(first)
+	end := e.buf.text.Len()
+	if last < e.lastLine() {
+		end = e.buf.text.LineStart(last + 1)
+	}
+	text := e.buf.text.Slice(start, end)
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	e.storeRegister(op, register{text: text, linewise: true})
+
+	lines := last - first + 1
+	switch op.name {
+	case "yank":
+		e.setCursor(cursorPos{first, e.colNum})
+		e.updateDisplay()
+		if lines > 1 {
+			e.updateStatusBar(fmt.Sprintf("%d lines yanked", lines))
+		}
+	case "delete":
+		e.deleteLines(first, last)
+		e.setCursor(cursorPos{first, e.firstNonBlank(min(first, e.lastLine()))})
+		e.updateDisplay()
+		if lines > 2 {
+			e.updateStatusBar(fmt.Sprintf("%d fewer lines", lines))
+		}
+	case "change":
+		lastLine := e.buf.text.Line(last)
+		e.deleteText(start, e.buf.text.LineStart(last)+len(lastLine)-start)
+		e.setCursor(cursorPos{first, 0})
+		e.editDone()
+		e.enterEditMode()
+	}
+}
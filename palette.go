@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Command palette
+//
+// Ctrl+P opens a popup that searches every command, open buffer and recent
+// file at once. The letters typed only have to appear in order, so "wn"
+// finds "wnext" and "spl" finds "split". Enter runs the selected entry.
+
+type paletteItem struct {
+	kind   string // "command", "buffer" or "recent"
+	label  string
+	keys   string
+	detail string
+	run    func()
+}
+
+// paletteMatch is an item that matches the typed pattern.
+type paletteMatch struct {
+	item      *paletteItem
+	score     int
+	positions []int // Matched runes of the label
+}
+
+// fuzzyMatch reports whether the runes of pattern appear in text in order,
+// ignoring case. Matches at the start of words and runs of consecutive
+// matches score higher. It returns the rune positions matched.
+func fuzzyMatch(pattern, text string) (int, []int, bool) {
+	if pattern == "" {
+		return 0, nil, true
+	}
+
+	want := []rune(strings.ToLower(pattern))
+	runes := []rune(text)
+	var positions []int
+	score, last := 0, -1
+	for i := 0; i < len(runes) && len(positions) < len(want); i++ {
+		if unicode.ToLower(runes[i]) != want[len(positions)] {
+			continue
+		}
+		score++
+		switch {
+		case last == i-1:
+			score += 5
+		case last >= 0:
+			score -= min(i-last-1, 3)
+		}
+		if i == 0 || !unicode.IsLetter(runes[i-1]) && !unicode.IsDigit(runes[i-1]) {
+			score += 3
+		}
+		positions = append(positions, i)
+		last = i
+	}
+	if len(positions) < len(want) {
+		return 0, nil, false
+	}
+	return score, positions, true
+}
+
+// paletteItems lists everything the palette can run.
+func (e *TextEditor) paletteItems() []*paletteItem {
+	var items []*paletteItem
+	for _, def := range commands {
+		def := def
+		items = append(items, &paletteItem{
+			kind:   "command",
+			label:  def.name,
+			keys:   e.keysText(def),
+			detail: def.desc,
+			run: func() {
+				if def.arg == argText {
+					// Let the user type the argument
+					e.startCommandLine()
+					e.prompt.SetText(def.name + " ")
+					return
+				}
+				e.runCommand(&binding{cmd: def}, "", 0)
+			},
+		})
+	}
+
+	for _, b := range e.buffers {
+		b := b
+		detail := b.path
+		if b.modified {
+			detail = "[+] " + detail
+		}
+		items = append(items, &paletteItem{
+			kind:   "buffer",
+			label:  b.name(),
+			detail: detail,
+			run:    func() { e.switchBuffer(b) },
+		})
+	}
+
+	for _, path := range e.recentFiles {
+		if e.findBuffer(path) != nil {
+			continue
+		}
+		path := path
+		items = append(items, &paletteItem{
+			kind:   "recent",
+			label:  filepath.Base(path),
+			detail: path,
+			run: func() {
+				if err := e.openBuffer(path); err != nil {
+					e.updateStatusBar(fmt.Sprintf("Error: %v", err))
+				}
+			},
+		})
+	}
+	return items
+}
+
+// filterPalette returns the items matching pattern, best first. Items whose
+// label doesn't match can still match on their description, ranked lower.
+func filterPalette(items []*paletteItem, pattern string) []paletteMatch {
+	var matches []paletteMatch
+	for _, item := range items {
+		if score, positions, ok := fuzzyMatch(pattern, item.label); ok {
+			matches = append(matches, paletteMatch{item, score, positions})
+		} else if score, _, ok := fuzzyMatch(pattern, item.detail); ok {
+			matches = append(matches, paletteMatch{item, score/2 - 10, nil})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	return matches
+}
+
+// highlightMatch colours the matched runes of label.
+func highlightMatch(label string, positions []int) string {
+	var result strings.Builder
+	matched := make(map[int]bool)
+	for _, p := range positions {
+		matched[p] = true
+	}
+	for i, r := range []rune(label) {
+		if matched[i] {
+			result.WriteString("[yellow::b]" + tview.Escape(string(r)) + "[-::-]")
+		} else {
+			result.WriteString(tview.Escape(string(r)))
+		}
+	}
+	return result.String()
+}
+
+// showPalette opens the command palette.
+func (e *TextEditor) showPalette() {
+	items := e.paletteItems()
+	var matches []paletteMatch
+
+	input := tview.NewInputField().
+		SetLabel("> ").
+		SetLabelColor(tcell.ColorYellow).
+		SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
+		SetFieldTextColor(tview.Styles.PrimaryTextColor)
+	table := tview.NewTable().
+		SetSelectable(true, false)
+
+	update := func(pattern string) {
+		matches = filterPalette(items, pattern)
+		table.Clear()
+		for row, m := range matches {
+			table.SetCell(row, 0, tview.NewTableCell(m.item.kind).SetTextColor(tcell.ColorGray))
+			table.SetCell(row, 1, tview.NewTableCell(highlightMatch(m.item.label, m.positions)).SetMaxWidth(24))
+			table.SetCell(row, 2, tview.NewTableCell(tview.Escape(m.item.keys)).SetTextColor(tcell.ColorAqua))
+			table.SetCell(row, 3, tview.NewTableCell(tview.Escape(m.item.detail)).SetExpansion(1))
+		}
+		table.Select(0, 0).ScrollToBeginning()
+	}
+	update("")
+
+	run := func() {
+		row, _ := table.GetSelection()
+		e.closeDialog()
+		e.updateDisplay()
+		if row >= 0 && row < len(matches) {
+			matches[row].item.run()
+		}
+	}
+
+	input.SetChangedFunc(update)
+	input.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		row, _ := table.GetSelection()
+		switch event.Key() {
+		case tcell.KeyEnter:
+			run()
+		case tcell.KeyEscape:
+			e.closeDialog()
+			e.updateDisplay()
+		case tcell.KeyUp, tcell.KeyCtrlP:
+			table.Select(max(row-1, 0), 0)
+		case tcell.KeyDown, tcell.KeyCtrlN, tcell.KeyTab:
+			table.Select(min(row+1, max(len(matches)-1, 0)), 0)
+		default:
+			return event
+		}
+		return nil
+	})
+
+	box := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(input, 1, 0, true).
+		AddItem(table, 0, 1, false)
+	box.SetBorder(true).SetTitle(" Command Palette ")
+
+	// Centre the palette on the screen
+	popup := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().
+			SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(box, 0, 6, true).
+			AddItem(nil, 0, 1, false), 0, 10, true).
+		AddItem(nil, 0, 1, false)
+
+	e.showingDialog = true
+	e.app.SetRoot(popup, true)
+}
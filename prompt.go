@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// The prompt is an input line that takes the place of the status bar while
+// the editor asks for something, such as a search pattern.
+
+func (e *TextEditor) newPrompt() *tview.InputField {
+	return tview.NewInputField().
+		SetLabelColor(tcell.ColorYellow).
+		SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
+		SetFieldTextColor(tview.Styles.PrimaryTextColor)
+}
+
+// showPrompt asks for a line of text. changed is called after every edit and
+// may be nil; done is called when Enter or Esc closes the prompt, with
+// accepted telling which of the two it was.
+func (e *TextEditor) showPrompt(label string, changed func(text string), done func(text string, accepted bool)) {
+	e.prompt.SetInputCapture(nil)
+	e.prompt.SetChangedFunc(nil).
+		SetText("").
+		SetLabel(label).
+		SetChangedFunc(changed).
+		SetDoneFunc(func(key tcell.Key) {
+			if key != tcell.KeyEnter && key != tcell.KeyEscape {
+				return
+			}
+			text := e.prompt.GetText()
+			e.prompting = false
+			e.app.SetRoot(e.getMainLayout(), true)
+			e.updateDisplay()
+			done(text, key == tcell.KeyEnter)
+		})
+
+	e.prompting = true
+	e.app.SetRoot(e.getMainLayout(), true)
+}
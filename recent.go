@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Recent files
+//
+// Files that were opened or saved are remembered across sessions in
+// ~/.config/swift/recent, newest first, one path per line.
+
+const maxRecentFiles = 50
+
+func recentFilesPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "recent")
+}
+
+// loadRecentFiles reads the recent files list, which may not exist yet.
+func loadRecentFiles() []string {
+	path := recentFilesPath()
+	if path == "" {
+		return nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return files
+}
+
+// addRecentFile moves path to the front of the recent files and saves the
+// list. Failing to save it isn't worth bothering the user about.
+func (e *TextEditor) addRecentFile(path string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+
+	files := []string{abs}
+	for _, file := range e.recentFiles {
+		if file != abs && len(files) < maxRecentFiles {
+			files = append(files, file)
+		}
+	}
+	e.recentFiles = files
+
+	if path := recentFilesPath(); path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			os.WriteFile(path, []byte(strings.Join(files, "\n")+"\n"), 0644)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/rivo/tview"
+)
+
+// Registers
+//
+// Deleted, changed and yanked text goes into registers, and p and P paste it
+// back after or before the cursor. '"' and a register name before a command
+// picks the register it uses, as in "ayy or "ap:
+//
+//	""        the unnamed register, the text of the last delete or yank
+//	"0        the last yank
+//	"1 - "9   the last nine deletes of whole lines or of text across lines,
+//	          newest first
+//	"-        the last delete within a line
+//	"a - "z   named registers; "A - "Z add to them instead of replacing
+//	"_        the black hole, which forgets what goes in
+//	"+ or "*  the system clipboard, see clipboard.go
+//
+// Lines paste as lines above or below the cursor's, blocks from Visual Mode
+// as a block, and other text within the line. In Edit Mode, Ctrl+R and a
+// register name types the register's text.
+
+// register holds the text of a delete, change or yank.
+type register struct {
+	text     string
+	linewise bool // Whole lines, each ending in a line break
+	block    bool // A block, lines separated by line breaks
+}
+
+// registerOrder is the order :registers lists them in.
+const registerOrder = "\"0123456789abcdefghijklmnopqrstuvwxyz-+"
+
+func validRegister(name rune) bool {
+	return name == '_' || name == '*' || name < unicode.MaxASCII && strings.ContainsRune(registerOrder, unicode.ToLower(name))
+}
+
+// isClipboard reports whether a register name is the system clipboard.
+func isClipboard(name rune) bool {
+	return name == '+' || name == '*'
+}
+
+// awaitRegister reads the name of the register the next command uses.
+func (e *TextEditor) awaitRegister(c *exCommand) {
+	e.awaitRune(c, func(op *operator, name rune) {
+		e.operator = op
+		if !validRegister(name) {
+			e.updateStatusBar(fmt.Sprintf("Error: invalid register name %q", name))
+			return
+		}
+		e.regName = name
+		e.count = c.count
+		e.updateDisplay()
+	})
+}
+
+// takeRegister returns the register picked for this command, the unnamed one
+// if none was, and forgets the pick.
+func (e *TextEditor) takeRegister() rune {
+	name := e.regName
+	e.regName = 0
+	if name == 0 {
+		return '"'
+	}
+	return name
+}
+
+// storeRegister puts the text an operator deleted, changed or yanked into
+// the register it was given, or the registers that text goes to by default.
+// The unnamed register gets it too.
+func (e *TextEditor) storeRegister(op *operator, r register) {
+	if e.registers == nil {
+		e.registers = make(map[rune]register)
+	}
+	name := op.reg
+	switch {
+	case name == '_':
+		return
+	case isClipboard(name):
+		name = '+'
+		e.registers[name] = r
+		e.copyToClipboard(r)
+	case unicode.IsUpper(name):
+		name = unicode.ToLower(name)
+		old := e.registers[name]
+		if old.linewise || r.linewise {
+			if old.text != "" && !strings.HasSuffix(old.text, "\n") {
+				old.text += "\n"
+			}
+			if !strings.HasSuffix(r.text, "\n") {
+				r.text += "\n"
+			}
+			r.linewise = true
+		}
+		r.text = old.text + r.text
+		e.registers[name] = r
+	case name != 0 && name != '"':
+		e.registers[name] = r
+	case op.name == "yank":
+		name = '0'
+		e.registers[name] = r
+	case r.linewise || strings.Contains(r.text, "\n"):
+		for n := '9'; n > '1'; n-- {
+			e.registers[n] = e.registers[n-1]
+		}
+		name = '1'
+		e.registers[name] = r
+	default:
+		name = '-'
+		e.registers[name] = r
+	}
+	e.registers['"'] = e.registers[name]
+}
+
+// getRegister returns the contents of a register, or an error if it's empty.
+func (e *TextEditor) getRegister(name rune) (register, error) {
+	r := e.registers[unicode.ToLower(name)]
+	if isClipboard(name) {
+		var err error
+		if r, err = e.clipboardRegister(); err != nil {
+			return r, err
+		}
+	}
+	if r.text == "" {
+		return r, fmt.Errorf("nothing in register %c", name)
+	}
+	return r, nil
+}
+
+// paste puts the text of the picked register after the cursor, or before it,
+// count times. Lines go below or above the cursor's line.
+func (e *TextEditor) paste(c *exCommand, before bool) error {
+	if e.showWelcome {
+		return nil
+	}
+	if e.mode == VisualMode {
+		e.enterViewMode()
+	}
+	r, err := e.getRegister(e.takeRegister())
+	if err != nil {
+		return err
+	}
+
+	e.buf.history.Close()
+	switch {
+	case r.linewise:
+		e.pasteLines(strings.Repeat(r.text, c.times()), before)
+	case r.block:
+		e.pasteBlock(strings.Split(r.text, "\n"), before, c.times())
+	default:
+		line := e.buf.text.Line(e.lineNum)
+		col := e.colNum
+		if !before && col < runeLen(line) {
+			col = nextGraphemeCol(line, col)
+		}
+		text := strings.Repeat(r.text, c.times())
+		e.insertText(e.buf.text.LineStart(e.lineNum)+byteOffset(line, col), text)
+
+		// The cursor ends on the last character pasted, or at the start of
+		// text that spans lines
+		if !strings.Contains(text, "\n") {
+			col += runeLen(text) - 1
+		}
+		e.setCursor(cursorPos{e.lineNum, col})
+	}
+	e.editDone()
+	e.buf.history.Close()
+	return nil
+}
+
+// pasteLines puts lines below or above the cursor's line.
+func (e *TextEditor) pasteLines(text string, before bool) {
+	line := e.lineNum
+	if !before {
+		line++
+	}
+	offset := e.buf.text.Len()
+	if line <= e.lastLine() {
+		offset = e.buf.text.LineStart(line)
+	} else if offset > 0 && !strings.HasSuffix(e.buf.text.Slice(offset-1, offset), "\n") {
+		// Below a last line that has no line break
+		text = "\n" + strings.TrimSuffix(text, "\n")
+	}
+	e.insertText(offset, text)
+	e.setCursor(cursorPos{line, e.firstNonBlank(min(line, e.lastLine()))})
+}
+
+// pasteBlock puts a block after or before the cursor, one part on each line
+// from the cursor's down, adding lines and padding short ones with spaces.
+func (e *TextEditor) pasteBlock(parts []string, before bool, times int) {
+	line := e.buf.text.Line(e.lineNum)
+	col := e.colNum
+	if !before && col < runeLen(line) {
+		col = nextGraphemeCol(line, col)
+	}
+	target := displayCol(line, col)
+	width := 0
+	for _, part := range parts {
+		width = max(width, displayWidth(part))
+	}
+
+	for i, part := range parts {
+		n := e.lineNum + i
+		if n > e.lastLine() {
+			e.insertText(e.buf.text.Len(), "\n")
+		}
+		text := e.buf.text.Line(n)
+		if w := displayWidth(text); w < target {
+			e.insertText(e.buf.text.LineStart(n)+len(text), strings.Repeat(" ", target-w))
+			text = e.buf.text.Line(n)
+		}
+		at := byteOffset(text, colForDisplay(text, target))
+		piece := strings.Repeat(part+strings.Repeat(" ", width-displayWidth(part)), times)
+		if at == len(text) {
+			piece = strings.TrimRight(piece, " ")
+		}
+		e.insertText(e.buf.text.LineStart(n)+at, piece)
+	}
+	e.setCursor(cursorPos{e.lineNum, col})
+}
+
+// awaitInsertRegister reads a register name in Edit Mode and types the
+// register's text.
+func (e *TextEditor) awaitInsertRegister(c *exCommand) {
+	e.awaitRune(c, func(op *operator, name rune) {
+		r, err := e.getRegister(name)
+		if err != nil {
+			e.updateStatusBar(fmt.Sprintf("Error: %v", err))
+			return
+		}
+		offset := e.cursorOffset()
+		e.insertText(offset, r.text)
+		e.setCursor(e.posAt(offset + len(r.text)))
+		e.editDone()
+	})
+}
+
+// showRegisters lists the registers that hold text. The clipboard shows what
+// SWIFT last copied to it.
+func (e *TextEditor) showRegisters() {
+	var text strings.Builder
+	text.WriteString("[yellow]Type Name Content[-]\n")
+	for _, name := range registerOrder {
+		r := e.registers[name]
+		if r.text == "" {
+			continue
+		}
+		kind := "c"
+		if r.linewise {
+			kind = "l"
+		} else if r.block {
+			kind = "b"
+		}
+		content := strings.NewReplacer("\n", "^J", "\t", "^I").Replace(r.text)
+		if runes := []rune(content); len(runes) > 64 {
+			content = string(runes[:64]) + "…"
+		}
+		text.WriteString(tview.Escape(fmt.Sprintf("  %s   \"%c   %s\n", kind, name, content)))
+	}
+	e.showText(" Registers - Esc to close ", text.String())
+}
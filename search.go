@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Search
+//
+// '/' searches forward and '?' backward, moving the cursor to the first match
+// while the pattern is typed. Matches are found within single lines. A
+// pattern without capital letters ignores case. Patterns are plain text
+// unless regex mode is switched on with Ctrl+R in the prompt.
+
+type searchState struct {
+	pattern  string
+	backward bool
+	regex    bool
+	re       *regexp.Regexp
+}
+
+// compileSearch builds the regexp for a search pattern.
+func compileSearch(pattern string, regex bool) (*regexp.Regexp, error) {
+	expr := pattern
+	if !regex {
+		expr = regexp.QuoteMeta(pattern)
+	}
+	if !hasUpper(pattern, regex) {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// hasUpper reports whether pattern contains a capital letter, not counting
+// escapes like \S in a regexp.
+func hasUpper(pattern string, regex bool) bool {
+	escaped := false
+	for _, r := range pattern {
+		if escaped {
+			escaped = false
+			continue
+		}
+		if regex && r == '\\' {
+			escaped = true
+			continue
+		}
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchFrom finds the next match of re after the byte offset col of line,
+// or before it if backward, wrapping around the ends of the buffer. It
+// returns the line and byte range of the match and whether it wrapped.
+func (e *TextEditor) searchFrom(re *regexp.Regexp, line, col int, backward bool) (matchLine, start, end int, wrapped, found bool) {
+	count := e.buf.text.LineCount()
+
+	// The cursor line is looked at twice: first the part after the cursor,
+	// and after wrapping around the part before it
+	for i := 0; i <= count; i++ {
+		n := line + i
+		if backward {
+			n = line - i
+		}
+		wrapped = n < 0 || n >= count
+		n = (n%count + count) % count
+
+		matches := re.FindAllStringIndex(e.buf.text.Line(n), -1)
+		if backward {
+			for j := len(matches) - 1; j >= 0; j-- {
+				m := matches[j]
+				if (i == 0 && m[0] >= col) || (i == count && m[0] < col) {
+					continue
+				}
+				return n, m[0], m[1], wrapped, true
+			}
+		} else {
+			for _, m := range matches {
+				if (i == 0 && m[0] <= col) || (i == count && m[0] > col) {
+					continue
+				}
+				return n, m[0], m[1], wrapped, true
+			}
+		}
+	}
+	return 0, 0, 0, false, false
+}
+
+// startSearch opens the search prompt.
+func (e *TextEditor) startSearch(backward bool) {
+	if e.showWelcome {
+		return
+	}
+
+	e.buf.history.Close()
+	origin := e.position()
+	previous := e.search
+	regex := e.searchRegex
+	label := func() string {
+		prefix := "/"
+		if backward {
+			prefix = "?"
+		}
+		if regex {
+			return "regex " + prefix
+		}
+		return prefix
+	}
+
+	// Move to the first match of the pattern typed so far
+	update := func(pattern string) {
+		e.setPosition(origin)
+		e.search = previous
+		if pattern != "" {
+			if re, err := compileSearch(pattern, regex); err == nil {
+				e.search = &searchState{pattern: pattern, backward: backward, regex: regex, re: re}
+				e.showMatches = true
+				if line, start, _, _, found := e.searchFrom(re, e.lineNum, byteOffset(e.buf.text.Line(e.lineNum), e.colNum), backward); found {
+					e.setCursor(cursorPos{line, runeLen(e.buf.text.Line(line)[:start])})
+				}
+			}
+		}
+		e.updateDisplay()
+	}
+
+	e.showPrompt(label(), update, func(pattern string, accepted bool) {
+		if !accepted || pattern == "" {
+			e.search = previous
+			e.setPosition(origin)
+			e.updateDisplay()
+			return
+		}
+
+		re, err := compileSearch(pattern, regex)
+		if err != nil {
+			e.search = previous
+			e.setPosition(origin)
+			e.updateDisplay()
+			e.updateStatusBar(fmt.Sprintf("Invalid pattern: %v", err))
+			return
+		}
+		e.search = &searchState{pattern: pattern, backward: backward, regex: regex, re: re}
+		e.searchRegex = regex
+		e.setPosition(origin)
+		e.searchNext(false)
+	})
+
+	// Ctrl+R switches between plain text and regexp patterns
+	e.prompt.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyCtrlR {
+			regex = !regex
+			e.prompt.SetLabel(label())
+			update(e.prompt.GetText())
+			return nil
+		}
+		return event
+	})
+}
+
+// searchNext moves to the next match of the last search, in the other
+// direction if reverse.
+func (e *TextEditor) searchNext(reverse bool) {
+	if e.search == nil {
+		e.updateStatusBar("No previous search")
+		return
+	}
+
+	e.buf.history.Close()
+	e.showMatches = true
+	backward := e.search.backward != reverse
+	line, start, _, wrapped, found := e.searchFrom(e.search.re, e.lineNum, byteOffset(e.buf.text.Line(e.lineNum), e.colNum), backward)
+	if !found {
+		e.updateDisplay()
+		e.updateStatusBar(fmt.Sprintf("Pattern not found: %s", e.search.pattern))
+		return
+	}
+
+	e.setCursor(cursorPos{line, runeLen(e.buf.text.Line(line)[:start])})
+	e.updateDisplay()
+	switch {
+	case wrapped && backward:
+		e.updateStatusBar("Search hit TOP, continuing at BOTTOM")
+	case wrapped:
+		e.updateStatusBar("Search hit BOTTOM, continuing at TOP")
+	}
+}
+
+// matchesIn returns the byte ranges of the search matches to highlight in
+// line.
+func (e *TextEditor) matchesIn(line string) [][2]int {
+	if e.search == nil || !e.showMatches {
+		return nil
+	}
+
+	var matches [][2]int
+	for _, m := range e.search.re.FindAllStringIndex(line, -1) {
+		if m[0] < m[1] {
+			matches = append(matches, [2]int{m[0], m[1]})
+		}
+	}
+	return matches
+}
+
+// currentMatchIn returns the bytes of line n of b drawn as the current match.
+func (e *TextEditor) currentMatchIn(b *Buffer, n int) [2]int {
+	if e.currentMatch == nil || b != e.buf || e.currentMatch[0] != n {
+		return [2]int{}
+	}
+	return [2]int{e.currentMatch[1], e.currentMatch[2]}
+}
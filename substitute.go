@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Substitute
+//
+// ":s/old/new/flags" replaces matches of the Go regexp old with new, which
+// may refer to capture groups as $1 or ${name}. It works on the current line,
+// or on a range in front of the s such as "%" for the whole buffer or "5,9".
+// Any punctuation character can take the place of the slashes. Flags:
+//
+//	g  replace every match in a line, not just the first
+//	i  ignore case
+//	c  ask before each replacement
+//
+// All replacements of one command are undone together.
+
+type substitution struct {
+	start, end int // Line range, both included
+	re         *regexp.Regexp
+	template   string
+	global     bool
+	confirm    bool
+}
+
+func isDelimiter(r rune) bool {
+	return r < utf8.RuneSelf && unicode.IsPrint(r) && !unicode.IsLetter(r) && !unicode.IsDigit(r) &&
+		r != ' ' && r != '\\' && r != '"'
+}
+
+// parseSubstitute parses the argument of a substitute command, like
+// "/old/new/g".
+func (e *TextEditor) parseSubstitute(c *exCommand) (*substitution, error) {
+	if c.arg == "" || !isDelimiter(rune(c.arg[0])) {
+		return nil, fmt.Errorf("usage: s/pattern/replacement/flags")
+	}
+	delimiter := c.arg[0]
+	parts := splitUnescaped(c.arg[1:], delimiter)
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("usage: s%cpattern%creplacement%cflags", delimiter, delimiter, delimiter)
+	}
+
+	sub := &substitution{start: c.start, end: c.end, template: parts[1]}
+	caseless := false
+	if len(parts) == 3 {
+		for _, flag := range parts[2] {
+			switch flag {
+			case 'g':
+				sub.global = true
+			case 'c':
+				sub.confirm = true
+			case 'i':
+				caseless = true
+			default:
+				return nil, fmt.Errorf("unknown flag: %c", flag)
+			}
+		}
+	}
+
+	// An empty pattern repeats the last search
+	pattern := parts[0]
+	if pattern == "" {
+		if e.search == nil {
+			return nil, fmt.Errorf("no previous search")
+		}
+		pattern = e.search.re.String()
+	}
+	if caseless {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sub.re = re
+	return sub, nil
+}
+
+// splitUnescaped splits s at every delimiter that isn't preceded by a
+// backslash, and turns escaped delimiters back into plain ones.
+func splitUnescaped(s string, delimiter byte) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delimiter:
+			part.WriteByte(delimiter)
+			i++
+		case s[i] == delimiter:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(s[i])
+		}
+	}
+	return append(parts, part.String())
+}
+
+// substitute runs a substitute command.
+func (e *TextEditor) substitute(c *exCommand) error {
+	if e.showWelcome {
+		return nil
+	}
+
+	sub, err := e.parseSubstitute(c)
+	if err != nil {
+		return err
+	}
+
+	e.buf.history.Close()
+	if sub.confirm {
+		e.confirmSubstitute(sub)
+		return nil
+	}
+
+	count, lines := 0, 0
+	for n := sub.start; n <= sub.end; n++ {
+		line := e.buf.text.Line(n)
+		replaced, matches := sub.replaceLine(line)
+		if matches == 0 {
+			continue
+		}
+		e.replaceText(e.buf.text.LineStart(n), len(line), replaced)
+		e.setCursor(cursorPos{n, 0})
+		count += matches
+		lines++
+	}
+	e.substituteDone(count, lines)
+	return nil
+}
+
+// replaceLine returns line with the matches replaced and how many there were.
+func (s *substitution) replaceLine(line string) (string, int) {
+	var result []byte
+	pos, count := 0, 0
+	for _, m := range s.re.FindAllStringSubmatchIndex(line, -1) {
+		result = append(result, line[pos:m[0]]...)
+		result = s.re.ExpandString(result, s.template, line, m)
+		pos = m[1]
+		count++
+		if !s.global {
+			break
+		}
+	}
+	return string(result) + line[pos:], count
+}
+
+// confirmSubstitute goes through the matches one by one, highlighting the
+// one in question apart from the others and asking whether to replace it.
+func (e *TextEditor) confirmSubstitute(sub *substitution) {
+	previousSearch, previousShow := e.search, e.showMatches
+	e.search = &searchState{pattern: sub.re.String(), regex: true, re: sub.re}
+	e.showMatches = true
+
+	count, lines, lastLine := 0, 0, -1
+	n, col := sub.start, 0
+	var match []int
+	asked := false
+
+	finish := func() {
+		e.capture = nil
+		e.currentMatch = nil
+		e.search, e.showMatches = previousSearch, previousShow
+		if count == 0 && asked {
+			e.buf.history.Close()
+			e.updateDisplay()
+			e.updateStatusBar("No substitutions")
+			return
+		}
+		e.substituteDone(count, lines)
+	}
+
+	// next finds the first match at or after col, moving down the range
+	next := func() bool {
+		for ; n <= sub.end; n, col = n+1, 0 {
+			for _, m := range sub.re.FindAllStringSubmatchIndex(e.buf.text.Line(n), -1) {
+				if m[0] >= col {
+					match = m
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	replace := func() {
+		line := e.buf.text.Line(n)
+		replaced := sub.re.ExpandString(nil, sub.template, line, match)
+		e.replaceText(e.buf.text.LineStart(n)+match[0], match[1]-match[0], string(replaced))
+		count++
+		if n != lastLine {
+			lines++
+			lastLine = n
+		}
+		col = match[0] + len(replaced)
+		if match[1] == match[0] {
+			col++
+		}
+		if !sub.global {
+			n, col = n+1, 0
+		}
+	}
+
+	skip := func() {
+		col = match[1]
+		if match[1] == match[0] {
+			col++
+		}
+		if !sub.global {
+			n, col = n+1, 0
+		}
+	}
+
+	ask := func() {
+		if !next() {
+			finish()
+			return
+		}
+		asked = true
+		line := e.buf.text.Line(n)
+		e.currentMatch = &[3]int{n, match[0], match[1]}
+		e.setCursor(cursorPos{n, runeLen(line[:match[0]])})
+		e.updateDisplay()
+		replacement := sub.re.ExpandString(nil, sub.template, line, match)
+		e.updateStatusBar(fmt.Sprintf("Replace with %q? (y)es (n)o (a)ll (q)uit", replacement))
+	}
+
+	e.capture = func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Rune() == 'y':
+			replace()
+		case event.Rune() == 'n':
+			skip()
+		case event.Rune() == 'a':
+			for {
+				replace()
+				if !next() {
+					break
+				}
+			}
+		case event.Rune() == 'q' || event.Key() == tcell.KeyEscape:
+			finish()
+			return nil
+		default:
+			return nil
+		}
+		ask()
+		return nil
+	}
+	ask()
+}
+
+func (e *TextEditor) substituteDone(count, lines int) {
+	if count == 0 {
+		e.updateDisplay()
+		e.updateStatusBar("Pattern not found")
+		return
+	}
+	e.editDone()
+	e.buf.history.Close()
+	e.updateStatusBar(fmt.Sprintf("%d substitutions on %d lines", count, lines))
+}
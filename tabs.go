@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// TabBar is the strip above the windows with a tab for every open buffer. If
+// the tabs don't fit, it scrolls so the active one stays visible.
+type TabBar struct {
+	*tview.Box
+	labels   []string
+	active   int
+	spans    [][2]int // Screen columns of the drawn tabs, for clicks
+	selected func(index int)
+	closed   func(index int)
+}
+
+func NewTabBar() *TabBar {
+	return &TabBar{Box: tview.NewBox()}
+}
+
+// SetTabs sets the tab labels and which of them is active.
+func (t *TabBar) SetTabs(labels []string, active int) *TabBar {
+	t.labels = labels
+	t.active = active
+	return t
+}
+
+// SetSelectedFunc sets the function called when a tab is clicked.
+func (t *TabBar) SetSelectedFunc(handler func(index int)) *TabBar {
+	t.selected = handler
+	return t
+}
+
+// SetClosedFunc sets the function called when a tab is middle-clicked.
+func (t *TabBar) SetClosedFunc(handler func(index int)) *TabBar {
+	t.closed = handler
+	return t
+}
+
+func (t *TabBar) Draw(screen tcell.Screen) {
+	t.Box.DrawForSubclass(screen, t)
+	x, y, width, _ := t.GetInnerRect()
+
+	// Drop tabs from the left until the active one fits
+	first := 0
+	for first < t.active {
+		used := 0
+		for _, label := range t.labels[first : t.active+1] {
+			used += displayWidth(label) + 1
+		}
+		if used <= width {
+			break
+		}
+		first++
+	}
+
+	t.spans = make([][2]int, len(t.labels))
+	col := x
+	if first > 0 {
+		tview.Print(screen, "<", col, y, 1, tview.AlignLeft, tcell.ColorGray)
+		col++
+	}
+	for i := first; i < len(t.labels) && col < x+width; i++ {
+		style := "[white:gray]"
+		if i == t.active {
+			style = "[black:aqua]"
+		}
+		_, drawn := tview.Print(screen, style+tview.Escape(t.labels[i]), col, y, x+width-col, tview.AlignLeft, tcell.ColorWhite)
+		t.spans[i] = [2]int{col, col + drawn}
+		col += drawn + 1
+	}
+}
+
+func (t *TabBar) MouseHandler() func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+	return t.WrapMouseHandler(func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+		if !t.InRect(event.Position()) {
+			return false, nil
+		}
+		x, _ := event.Position()
+		for i, span := range t.spans {
+			if x < span[0] || x >= span[1] {
+				continue
+			}
+			switch {
+			case action == tview.MouseLeftClick && t.selected != nil:
+				t.selected(i)
+			case action == tview.MouseMiddleClick && t.closed != nil:
+				t.closed(i)
+			}
+			break
+		}
+		// Clicking the bar doesn't take the focus from the window
+		return true, nil
+	})
+}
+
+// updateTabBar shows the open buffers in the tab bar.
+func (e *TextEditor) updateTabBar() {
+	labels := make([]string, len(e.buffers))
+	for i, b := range e.buffers {
+		labels[i] = fmt.Sprintf(" %d %s ", i+1, b.name())
+		if b.modified {
+			labels[i] = fmt.Sprintf(" %d %s [+] ", i+1, b.name())
+		}
+	}
+	e.tabBar.SetTabs(labels, e.bufferIndex(e.buf))
+}
+
+// gotoTab switches to the buffer in tab index, counting from zero.
+func (e *TextEditor) gotoTab(index int) {
+	if index < 0 || index >= len(e.buffers) {
+		e.updateStatusBar(fmt.Sprintf("No tab %d", index+1))
+		return
+	}
+	e.switchBuffer(e.buffers[index])
+}
+
+// moveTab moves the current buffer delta places along the tab bar.
+func (e *TextEditor) moveTab(delta int) {
+	from := e.bufferIndex(e.buf)
+	to := min(max(from+delta, 0), len(e.buffers)-1)
+	if to == from {
+		return
+	}
+	e.buffers = append(e.buffers[:from], e.buffers[from+1:]...)
+	e.buffers = append(e.buffers[:to], append([]*Buffer{e.buf}, e.buffers[to:]...)...)
+	e.updateDisplay()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/rivo/uniseg"
+)
+
+// Rune and grapheme helpers
+//
+// Cursor columns are rune offsets into a line. Horizontal movement steps over
+// whole grapheme clusters so combining marks and emoji sequences are never
+// split, and screen positions are measured in display cells.
+
+func runeLen(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// byteOffset converts a rune column in line into a byte offset.
+func byteOffset(line string, col int) int {
+	if col <= 0 {
+		return 0
+	}
+	for i := range line {
+		if col == 0 {
+			return i
+		}
+		col--
+	}
+	return len(line)
+}
+
+// nextGraphemeCol returns the rune column just past the grapheme cluster at col.
+func nextGraphemeCol(line string, col int) int {
+	pos := 0
+	state := -1
+	rest := line
+	var cluster string
+	for rest != "" {
+		cluster, rest, _, state = uniseg.FirstGraphemeClusterInString(rest, state)
+		pos += runeLen(cluster)
+		if pos > col {
+			return pos
+		}
+	}
+	return pos
+}
+
+// prevGraphemeCol returns the rune column where the grapheme cluster before
+// col starts.
+func prevGraphemeCol(line string, col int) int {
+	pos := 0
+	state := -1
+	rest := line
+	var cluster string
+	for rest != "" {
+		cluster, rest, _, state = uniseg.FirstGraphemeClusterInString(rest, state)
+		next := pos + runeLen(cluster)
+		if next >= col {
+			return pos
+		}
+		pos = next
+	}
+	return pos
+}
+
+// displayWidth returns the number of screen cells s occupies.
+func displayWidth(s string) int {
+	return runewidth.StringWidth(s)
+}
+
+// displayCol returns the screen column of the rune column col in line.
+func displayCol(line string, col int) int {
+	return displayWidth(line[:byteOffset(line, col)])
+}
+
+// colForDisplay returns the rune column of the grapheme cluster covering the
+// screen column width, or the end of the line if it is shorter.
+func colForDisplay(line string, width int) int {
+	pos := 0
+	cells := 0
+	state := -1
+	rest := line
+	var cluster string
+	for rest != "" {
+		cluster, rest, _, state = uniseg.FirstGraphemeClusterInString(rest, state)
+		cells += displayWidth(cluster)
+		if cells > width {
+			return pos
+		}
+		pos += runeLen(cluster)
+	}
+	return pos
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Text objects
+//
+// After an operator, i and a pick a text object instead of a motion: "diw"
+// deletes the word under the cursor, "ci(" changes what is inside the
+// parentheses and "yap" copies the paragraph with the blank lines after it.
+// The character after i or a names the object:
+//
+//	w W       word, or run of non-blanks
+//	" ' `     quoted string
+//	( ) b     parentheses
+//	[ ]       brackets
+//	{ } B     braces
+//	< >       angle brackets
+//	t         XML or HTML tag
+//	p         paragraph
+//
+// i takes what is inside the object, a the object itself, with the blanks
+// after it for words, strings and paragraphs. Quotes and brackets follow the
+// highlighter, so a bracket in a string or comment doesn't pair with one in
+// the code around it, even when the string or comment spans several lines.
+
+// objectPairs are the open and close characters of the bracket objects.
+var objectPairs = map[rune][2]byte{
+	'(': {'(', ')'}, ')': {'(', ')'}, 'b': {'(', ')'},
+	'[': {'[', ']'}, ']': {'[', ']'},
+	'{': {'{', '}'}, '}': {'{', '}'}, 'B': {'{', '}'},
+	'<': {'<', '>'}, '>': {'<', '>'},
+}
+
+var tagPattern = regexp.MustCompile(`<(/?)([A-Za-z][\w:.-]*)[^<>]*?(/?)>`)
+
+// awaitObject reads the character naming a text object, then runs the
+// waiting operator on it, or selects it in Visual Mode.
+func (e *TextEditor) awaitObject(c *exCommand, around bool) error {
+	if e.operator == nil && e.mode != VisualMode {
+		return fmt.Errorf("%s works after d, c or y, or in Visual Mode", c.name)
+	}
+	e.awaitRune(c, func(op *operator, r rune) {
+		from, to, kind, ok := e.textObject(r, around, motionCount(op, c.count))
+		switch {
+		case !ok:
+			e.updateDisplay()
+		case op == nil:
+			e.selectObject(from, to, kind)
+		default:
+			e.operate(op, from, to, kind)
+		}
+	})
+	return nil
+}
+
+// textObject returns the start and end of the count-th object around the
+// cursor. The end is exclusive unless the object is linewise.
+func (e *TextEditor) textObject(r rune, around bool, count int) (cursorPos, cursorPos, motionKind, bool) {
+	count = max(count, 1)
+	switch r {
+	case 'w', 'W':
+		from, to := e.wordObject(around, r == 'W', count)
+		return from, to, exclusive, true
+	case '"', '\'', '`':
+		from, to, ok := e.quoteObject(r, around)
+		return from, to, exclusive, ok
+	case 't':
+		from, to, ok := e.tagObject(around, count)
+		return from, to, exclusive, ok
+	case 'p':
+		first, last := e.paragraphObject(around, count)
+		return cursorPos{first, 0}, cursorPos{last, 0}, linewise, true
+	}
+	if pair, ok := objectPairs[r]; ok {
+		return e.bracketObject(pair[0], pair[1], around, count)
+	}
+	return cursorPos{}, cursorPos{}, exclusive, false
+}
+
+// wordObject returns count words from the one under the cursor, with the
+// blanks after them, or before them if there are none after, when around.
+// Runs of blanks count as words.
+func (e *TextEditor) wordObject(around, big bool, count int) (cursorPos, cursorPos) {
+	runes := []rune(e.buf.text.Line(e.lineNum))
+	n := len(runes)
+	if n == 0 {
+		return e.cursor(), e.cursor()
+	}
+	class := func(i int) int {
+		c := charClass(runes[i])
+		if big && c != 0 {
+			return 1
+		}
+		return c
+	}
+	runEnd := func(i int) int {
+		c := class(i)
+		for i < n && class(i) == c {
+			i++
+		}
+		return i
+	}
+
+	col := min(e.colNum, n-1)
+	start, end := col, col
+	for start > 0 && class(start-1) == class(col) {
+		start--
+	}
+	for i := 0; i < count && end < n; i++ {
+		blank := class(end) == 0
+		end = runEnd(end)
+		if !around || end >= n {
+			continue
+		}
+		if blank || class(end) == 0 {
+			end = runEnd(end)
+		}
+	}
+	if around && class(col) != 0 && class(end-1) != 0 {
+		for start > 0 && class(start-1) == 0 {
+			start--
+		}
+	}
+	return cursorPos{e.lineNum, start}, cursorPos{e.lineNum, end}
+}
+
+// paragraphObject returns the first and last line of count paragraphs from
+// the cursor's, where runs of blank lines count as paragraphs too. Around
+// takes the blank lines after them, or before them if there are none after.
+func (e *TextEditor) paragraphObject(around bool, count int) (int, int) {
+	// The empty line after the final line break isn't part of the text
+	limit := e.lastLine()
+	if limit > 0 && e.isEmptyLine(limit) {
+		limit--
+	}
+	blank := func(n int) bool {
+		return strings.TrimSpace(e.buf.text.Line(n)) == ""
+	}
+	runEnd := func(n int) int {
+		b := blank(n)
+		for n < limit && blank(n+1) == b {
+			n++
+		}
+		return n
+	}
+
+	line := min(e.lineNum, limit)
+	first, last := line, line-1
+	for first > 0 && blank(first-1) == blank(line) {
+		first--
+	}
+	for i := 0; i < count && last < limit; i++ {
+		wasBlank := blank(last + 1)
+		last = runEnd(last + 1)
+		if around && last < limit && (wasBlank || blank(last+1)) {
+			last = runEnd(last + 1)
+		}
+	}
+	if around && !blank(line) && !blank(last) {
+		for first > 0 && blank(first-1) {
+			first--
+		}
+	}
+	return first, last
+}
+
+// quoteObject returns the string around the cursor, or the next one on the
+// line. Strings are taken from the highlighter when it knows the quote, and
+// may then span several lines; otherwise they are found by pairing the
+// quotes on the line outside comments.
+func (e *TextEditor) quoteObject(q rune, around bool) (cursorPos, cursorPos, bool) {
+	line := e.buf.text.Line(e.lineNum)
+	cursor := bytePos{e.lineNum, byteOffset(line, e.colNum)}
+	tokens := e.buf.syntax.Tokens(e.lineNum)
+
+	// Each string is its start and end, and the length of its quotes
+	type quoted struct {
+		start, end  bytePos
+		open, close int
+	}
+	var strs []quoted
+	for _, t := range tokens {
+		if t.Kind != TokenString {
+			continue
+		}
+		start, end, _ := e.textSpan(e.lineNum, t.Start)
+		multiline := start.line != end.line
+		first := e.buf.text.Line(start.line)[start.offset:]
+		if !multiline {
+			first = first[:end.offset-start.offset]
+		}
+		last := e.buf.text.Line(end.line)[:end.offset]
+		i := strings.IndexRune(first, q)
+		if i < 0 || i > 2 || strings.TrimLeftFunc(first[:i], unicode.IsLetter) != "" {
+			continue
+		}
+		open := 1
+		if triple := strings.Repeat(string(q), 3); strings.HasPrefix(first[i:], triple) && (multiline || len(first) >= i+6) {
+			open = 3
+		}
+		close := 0
+		if (multiline || len(first) >= i+2*open) && strings.HasSuffix(last, strings.Repeat(string(q), open)) {
+			close = open
+		}
+		strs = append(strs, quoted{start, end, i + open, close})
+	}
+	if len(strs) == 0 {
+		var quotes []int
+		for i, r := range line {
+			if r == q && !inComment(tokens, i) && (i == 0 || line[i-1] != '\\') {
+				quotes = append(quotes, i)
+			}
+		}
+		for i := 0; i+1 < len(quotes); i += 2 {
+			strs = append(strs, quoted{bytePos{e.lineNum, quotes[i]}, bytePos{e.lineNum, quotes[i+1] + 1}, 1, 1})
+		}
+	}
+
+	for _, s := range strs {
+		if !cursor.before(s.end) {
+			continue
+		}
+		start, end := bytePos{s.start.line, s.start.offset + s.open}, bytePos{s.end.line, s.end.offset - s.close}
+		if around {
+			start, end = s.start, s.end
+			endLine := e.buf.text.Line(end.line)
+			if trimmed := strings.TrimLeft(endLine[end.offset:], " \t"); len(trimmed) < len(endLine[end.offset:]) {
+				end.offset = len(endLine) - len(trimmed)
+			} else {
+				start.offset = len(strings.TrimRight(e.buf.text.Line(start.line)[:start.offset], " \t"))
+			}
+		}
+		return e.posIn(start.line, start.offset), e.posIn(end.line, end.offset), true
+	}
+	return cursorPos{}, cursorPos{}, false
+}
+
+// inComment reports whether the byte at i is in a comment token.
+func inComment(tokens []Token, i int) bool {
+	for _, t := range tokens {
+		if t.Kind == TokenComment && t.Start <= i && i < t.End {
+			return true
+		}
+	}
+	return false
+}
+
+// textTokenAt returns the string or comment token at offset.
+func textTokenAt(tokens []Token, offset int) (Token, bool) {
+	for _, t := range tokens {
+		if (t.Kind == TokenString || t.Kind == TokenComment) && t.Start <= offset && offset < t.End {
+			return t, true
+		}
+	}
+	return Token{}, false
+}
+
+// bytePos is a position in the buffer as a line and byte offset in it.
+type bytePos struct{ line, offset int }
+
+func (p bytePos) before(q bytePos) bool {
+	return p.line < q.line || p.line == q.line && p.offset < q.offset
+}
+
+// textSpan returns the start and end of the string or comment at offset in
+// line n. Tokens are per line, so one that starts or ends a line is followed
+// onto the lines around it for as long as the highlighter's state says the
+// construct stays open.
+func (e *TextEditor) textSpan(n, offset int) (bytePos, bytePos, bool) {
+	t, ok := textTokenAt(e.buf.syntax.Tokens(n), offset)
+	if !ok {
+		return bytePos{}, bytePos{}, false
+	}
+	from, to := bytePos{n, t.Start}, bytePos{n, t.End}
+
+	for from.offset == 0 && from.line > 0 && e.buf.syntax.State(from.line) != 0 {
+		above := from.line - 1
+		length := len(e.buf.text.Line(above))
+		from = bytePos{above, length}
+		if t, ok := textTokenAt(e.buf.syntax.Tokens(above), length-1); ok && t.End == length {
+			from.offset = t.Start
+		}
+	}
+	for to.line < e.lastLine() && to.offset == len(e.buf.text.Line(to.line)) && e.buf.syntax.State(to.line+1) != 0 {
+		below := to.line + 1
+		to = bytePos{below, 0}
+		if t, ok := textTokenAt(e.buf.syntax.Tokens(below), 0); ok {
+			to.offset = t.End
+		}
+	}
+	return from, to, true
+}
+
+// bracketScanner finds the brackets that pair with the one around the
+// cursor, telling them from those in other strings and comments. Inside a
+// string or comment only that one is searched.
+type bracketScanner struct {
+	e           *TextEditor
+	inText      bool
+	from, to    bytePos // String or comment around the cursor, if inText
+	first, last int     // Lines searched
+}
+
+func (e *TextEditor) newBracketScanner(cursor bytePos) *bracketScanner {
+	s := &bracketScanner{e: e, last: e.lastLine()}
+	s.from, s.to, s.inText = e.textSpan(cursor.line, cursor.offset)
+	if s.inText {
+		s.first, s.last = s.from.line, s.to.line
+	}
+	return s
+}
+
+// line returns the text of line n, and its tokens when they are needed to
+// tell code from strings and comments.
+func (s *bracketScanner) line(n int) (string, []Token) {
+	if s.inText {
+		return s.e.buf.text.Line(n), nil
+	}
+	return s.e.buf.text.Line(n), s.e.buf.syntax.Tokens(n)
+}
+
+// counts reports whether a bracket at offset i of line n can pair with the
+// cursor's: both are in code, or both in the same string or comment.
+func (s *bracketScanner) counts(n, i int, tokens []Token) bool {
+	if s.inText {
+		p := bytePos{n, i}
+		return !p.before(s.from) && p.before(s.to)
+	}
+	_, ok := textTokenAt(tokens, i)
+	return !ok
+}
+
+// findOpen finds the unmatched open bracket at or before p. The offset of p
+// may be -1 to start at the end of the line above.
+func (s *bracketScanner) findOpen(p bytePos, open, close byte) (bytePos, bool) {
+	depth := 0
+	for n := p.line; n >= s.first; n-- {
+		line, tokens := s.line(n)
+		i := len(line) - 1
+		if n == p.line {
+			i = min(p.offset, i)
+		}
+		for ; i >= 0; i-- {
+			if line[i] != open && line[i] != close || !s.counts(n, i, tokens) {
+				continue
+			}
+			if line[i] == close {
+				depth++
+				continue
+			}
+			if depth == 0 {
+				return bytePos{n, i}, true
+			}
+			depth--
+		}
+	}
+	return p, false
+}
+
+// findClose finds the unmatched close bracket at or after p. The offset of p
+// may be the length of its line to start on the line below.
+func (s *bracketScanner) findClose(p bytePos, open, close byte) (bytePos, bool) {
+	depth := 0
+	for n := p.line; n <= s.last; n++ {
+		line, tokens := s.line(n)
+		i := 0
+		if n == p.line {
+			i = p.offset
+		}
+		for ; i < len(line); i++ {
+			if line[i] != open && line[i] != close || !s.counts(n, i, tokens) {
+				continue
+			}
+			if line[i] == open {
+				depth++
+				continue
+			}
+			if depth == 0 {
+				return bytePos{n, i}, true
+			}
+			depth--
+		}
+	}
+	return p, false
+}
+
+// bracketObject returns the count-th pair of brackets around the cursor.
+// Inside brackets that are alone at the end and start of their lines, the
+// object is the lines between them.
+func (e *TextEditor) bracketObject(open, close byte, around bool, count int) (cursorPos, cursorPos, motionKind, bool) {
+	line := e.buf.text.Line(e.lineNum)
+	cursor := bytePos{e.lineNum, byteOffset(line, e.colNum)}
+	s := e.newBracketScanner(cursor)
+
+	var from, to bytePos
+	found := false
+	if _, tokens := s.line(cursor.line); cursor.offset < len(line) && line[cursor.offset] == close && s.counts(cursor.line, cursor.offset, tokens) {
+		to = cursor
+		from, found = s.findOpen(bytePos{cursor.line, cursor.offset - 1}, open, close)
+	} else {
+		from, found = s.findOpen(cursor, open, close)
+		if found {
+			to, found = s.findClose(bytePos{from.line, from.offset + 1}, open, close)
+		}
+	}
+	for i := 1; i < count && found; i++ {
+		if from, found = s.findOpen(bytePos{from.line, from.offset - 1}, open, close); found {
+			to, found = s.findClose(bytePos{from.line, from.offset + 1}, open, close)
+		}
+	}
+	if !found {
+		return cursorPos{}, cursorPos{}, exclusive, false
+	}
+
+	if around {
+		return e.posIn(from.line, from.offset), e.posIn(to.line, to.offset+1), exclusive, true
+	}
+	openLine, closeLine := e.buf.text.Line(from.line), e.buf.text.Line(to.line)
+	if to.line > from.line+1 && strings.TrimSpace(openLine[from.offset+1:]) == "" && strings.TrimSpace(closeLine[:to.offset]) == "" {
+		return cursorPos{from.line + 1, 0}, cursorPos{to.line - 1, 0}, linewise, true
+	}
+	return e.posIn(from.line, from.offset+1), e.posIn(to.line, to.offset), exclusive, true
+}
+
+// tagObject returns the count-th element around the cursor, from its start
+// tag to its end tag.
+func (e *TextEditor) tagObject(around bool, count int) (cursorPos, cursorPos, bool) {
+	text := e.buf.text.String()
+	cursor := e.cursorOffset()
+
+	type tag struct {
+		name       string
+		start, end int
+	}
+	var open []tag
+	var elements [][2]tag
+	for _, m := range tagPattern.FindAllStringSubmatchIndex(text, -1) {
+		t := tag{text[m[4]:m[5]], m[0], m[1]}
+		switch {
+		case m[7] > m[6]:
+			// Self-closing
+		case m[3] == m[2]:
+			open = append(open, t)
+		default:
+			// Close the innermost element of the same name, dropping any
+			// unclosed ones inside it
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i].name == t.name {
+					elements = append(elements, [2]tag{open[i], t})
+					open = open[:i]
+					break
+				}
+			}
+		}
+	}
+
+	// Elements close inner ones first, so the ones around the cursor come
+	// innermost first
+	for _, el := range elements {
+		if el[0].start > cursor || cursor >= el[1].end {
+			continue
+		}
+		if count--; count > 0 {
+			continue
+		}
+		if around {
+			return e.posAt(el[0].start), e.posAt(el[1].end), true
+		}
+		return e.posAt(el[0].end), e.posAt(el[1].start), true
+	}
+	return cursorPos{}, cursorPos{}, false
+}
+
+// posIn returns the position of a byte offset in line n.
+func (e *TextEditor) posIn(n, offset int) cursorPos {
+	return cursorPos{n, utf8.RuneCountInString(e.buf.text.Line(n)[:offset])}
+}
+
+// posAt returns the position of a byte offset in the buffer.
+func (e *TextEditor) posAt(offset int) cursorPos {
+	n := e.buf.text.LineOf(offset)
+	return e.posIn(n, offset-e.buf.text.LineStart(n))
+}
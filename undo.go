@@ -0,0 +1,125 @@
+package main
+
+// Undo history
+//
+// Every change to a TextBuffer is recorded as an editOp. Operations are
+// collected into groups, and a group is what a single undo or redo reverts or
+// reapplies. A group stays open while the user keeps typing and is closed by
+// cursor movement, mode changes and saves, so a run of typing undoes in one
+// step.
+
+type editOp struct {
+	offset   int
+	deleted  string
+	inserted string
+}
+
+type cursorPos struct {
+	line int
+	col  int
+}
+
+type undoGroup struct {
+	seq    int
+	ops    []editOp
+	before cursorPos
+	after  cursorPos
+}
+
+type History struct {
+	undo     []undoGroup
+	redo     []undoGroup
+	open     bool
+	nextSeq  int
+	savedSeq int
+}
+
+func NewHistory() *History {
+	return &History{nextSeq: 1}
+}
+
+// Record adds op to the open group, starting a new group at cursor if none is
+// open. Recording a new change discards everything that could be redone.
+func (h *History) Record(op editOp, cursor cursorPos) {
+	h.redo = nil
+	if !h.open || len(h.undo) == 0 {
+		h.undo = append(h.undo, undoGroup{seq: h.nextSeq, before: cursor})
+		h.nextSeq++
+		h.open = true
+	}
+
+	group := &h.undo[len(h.undo)-1]
+	if n := len(group.ops); n > 0 {
+		last := &group.ops[n-1]
+		if last.deleted == "" && op.deleted == "" && last.offset+len(last.inserted) == op.offset {
+			last.inserted += op.inserted
+			return
+		}
+	}
+	group.ops = append(group.ops, op)
+}
+
+// SetCursor stores the cursor position reached after the open group's edits.
+func (h *History) SetCursor(cursor cursorPos) {
+	if h.open && len(h.undo) > 0 {
+		h.undo[len(h.undo)-1].after = cursor
+	}
+}
+
+// Close ends the open group so the next change starts a new undo step.
+func (h *History) Close() {
+	h.open = false
+}
+
+// Undo reverts the most recent group in buf and returns the cursor position
+// from before it was made.
+func (h *History) Undo(buf *TextBuffer) (cursorPos, bool) {
+	h.open = false
+	if len(h.undo) == 0 {
+		return cursorPos{}, false
+	}
+	group := h.undo[len(h.undo)-1]
+	h.undo = h.undo[:len(h.undo)-1]
+	for i := len(group.ops) - 1; i >= 0; i-- {
+		op := group.ops[i]
+		buf.Delete(op.offset, len(op.inserted))
+		buf.Insert(op.offset, op.deleted)
+	}
+	h.redo = append(h.redo, group)
+	return group.before, true
+}
+
+// Redo reapplies the most recently undone group in buf and returns the cursor
+// position from after it was made.
+func (h *History) Redo(buf *TextBuffer) (cursorPos, bool) {
+	h.open = false
+	if len(h.redo) == 0 {
+		return cursorPos{}, false
+	}
+	group := h.redo[len(h.redo)-1]
+	h.redo = h.redo[:len(h.redo)-1]
+	for _, op := range group.ops {
+		buf.Delete(op.offset, len(op.deleted))
+		buf.Insert(op.offset, op.inserted)
+	}
+	h.undo = append(h.undo, group)
+	return group.after, true
+}
+
+// MarkSaved remembers the current state as the one written to disk.
+func (h *History) MarkSaved() {
+	h.open = false
+	h.savedSeq = h.currentSeq()
+}
+
+// AtSaved reports whether the buffer matches the state written to disk.
+func (h *History) AtSaved() bool {
+	return h.currentSeq() == h.savedSeq
+}
+
+func (h *History) currentSeq() int {
+	if len(h.undo) == 0 {
+		return 0
+	}
+	return h.undo[len(h.undo)-1].seq
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Viewport is the editor's text area. Instead of holding the whole file it
+// asks its renderer for the visible rows every time it is drawn, so the cost
+// of a redraw depends on the size of the screen and not on the file.
+type Viewport struct {
+	*tview.TextView
+	render func(width, height int) string
+}
+
+func NewViewport() *Viewport {
+	return &Viewport{
+		TextView: tview.NewTextView().
+			SetDynamicColors(true).
+			SetRegions(true).
+			SetWordWrap(false).
+			SetScrollable(true),
+	}
+}
+
+// SetRenderer makes the viewport draw the rows returned by render.
+func (v *Viewport) SetRenderer(render func(width, height int) string) *Viewport {
+	v.render = render
+	return v
+}
+
+// ShowText replaces the rendered rows with a fixed text, such as the welcome
+// screen, until the next SetRenderer.
+func (v *Viewport) ShowText(text string) *Viewport {
+	v.render = nil
+	v.TextView.SetText(text)
+	v.TextView.ScrollToBeginning()
+	return v
+}
+
+func (v *Viewport) Draw(screen tcell.Screen) {
+	if v.render != nil {
+		_, _, width, height := v.GetInnerRect()
+		v.TextView.SetText(v.render(width, height))
+		v.TextView.ScrollTo(0, 0)
+	}
+	v.TextView.Draw(screen)
+}
+
+// scrollToCursor returns the first line to show so that line stays at least
+// scrollOff lines away from the top and bottom edges of a view with height
+// rows, moving as little as possible from top.
+func scrollToCursor(top, line, height, scrollOff, lineCount int) int {
+	if height <= 0 {
+		return line
+	}
+	scrollOff = min(scrollOff, (height-1)/2)
+
+	if line-scrollOff < top {
+		top = line - scrollOff
+	}
+	if line+scrollOff >= top+height {
+		top = line + scrollOff - height + 1
+	}
+	top = min(top, lineCount-height)
+	return max(top, 0)
+}
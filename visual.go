@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"unicode"
+)
+
+// Visual Mode
+//
+// v, V and Ctrl+V start selecting characters, whole lines or a rectangular
+// block from the cursor. Movement keys and text objects then extend the
+// selection, and d (or x), c and y delete, change or copy it. > and < indent
+// the selected lines, ~, u and U change the case of the selection, and !
+// filters the lines through a shell command. ':' types a command for the
+// selected lines.
+
+type visualKind int
+
+const (
+	visualChar visualKind = iota
+	visualLine
+	visualBlock
+)
+
+var visualNames = map[visualKind]string{
+	visualChar:  "Visual",
+	visualLine:  "Visual Line",
+	visualBlock: "Visual Block",
+}
+
+// selection is the text selected in Visual Mode, from anchor to the cursor.
+type selection struct {
+	kind   visualKind
+	anchor cursorPos
+}
+
+// startVisual starts selecting, switches to another kind of selection, or
+// stops selecting when the selection already is of that kind.
+func (e *TextEditor) startVisual(kind visualKind) {
+	if e.showWelcome {
+		return
+	}
+	switch {
+	case e.mode != VisualMode:
+		e.buf.history.Close()
+		e.mode = VisualMode
+		e.selection = selection{kind: kind, anchor: e.cursor()}
+	case e.selection.kind == kind:
+		e.enterViewMode()
+	default:
+		e.selection.kind = kind
+	}
+	e.updateDisplay()
+}
+
+// swapEnds moves the cursor to the other end of the selection.
+func (e *TextEditor) swapEnds() {
+	if e.mode != VisualMode {
+		return
+	}
+	anchor := e.selectionAnchor()
+	e.selection.anchor = e.cursor()
+	e.setCursor(anchor)
+	e.updateDisplay()
+}
+
+// selectionAnchor returns the anchor, kept within the buffer in case it
+// changed.
+func (e *TextEditor) selectionAnchor() cursorPos {
+	line := min(e.selection.anchor.line, e.lastLine())
+	return cursorPos{line, min(e.selection.anchor.col, runeLen(e.buf.text.Line(line)))}
+}
+
+// selectionBounds returns the ends of the selection, first one first.
+func (e *TextEditor) selectionBounds() (cursorPos, cursorPos) {
+	from, to := e.selectionAnchor(), e.cursor()
+	if to.line < from.line || to.line == from.line && to.col < from.col {
+		from, to = to, from
+	}
+	return from, to
+}
+
+// selectedLines returns the first and last line the selection touches.
+func (e *TextEditor) selectedLines() (int, int) {
+	from, to := e.selectionBounds()
+	return from.line, to.line
+}
+
+// blockColumns returns the screen columns a block selection spans, both
+// included.
+func (e *TextEditor) blockColumns() (int, int) {
+	anchor, cursor := e.selectionAnchor(), e.cursor()
+	a := displayCol(e.buf.text.Line(anchor.line), anchor.col)
+	b := displayCol(e.buf.text.Line(cursor.line), cursor.col)
+	return min(a, b), max(a, b)
+}
+
+// selectionIn returns the bytes of line n that are selected.
+func (e *TextEditor) selectionIn(n int) (int, int) {
+	from, to := e.selectionBounds()
+	if n < from.line || n > to.line {
+		return 0, 0
+	}
+	line := e.buf.text.Line(n)
+	switch e.selection.kind {
+	case visualLine:
+		return 0, len(line)
+	case visualBlock:
+		left, right := e.blockColumns()
+		start := colForDisplay(line, left)
+		end := colForDisplay(line, right)
+		if end < runeLen(line) {
+			end = nextGraphemeCol(line, end)
+		}
+		return byteOffset(line, start), byteOffset(line, end)
+	}
+	start, end := 0, len(line)
+	if n == from.line {
+		start = byteOffset(line, from.col)
+	}
+	if n == to.line && to.col < runeLen(line) {
+		end = byteOffset(line, nextGraphemeCol(line, to.col))
+	}
+	return start, end
+}
+
+// selectObject selects a text object, as "viw" or "va(" do.
+func (e *TextEditor) selectObject(from, to cursorPos, kind motionKind) {
+	if kind == linewise {
+		e.selection = selection{kind: visualLine, anchor: cursorPos{from.line, 0}}
+		e.setCursor(cursorPos{to.line, 0})
+		e.updateDisplay()
+		return
+	}
+	if from == to {
+		e.updateDisplay()
+		return
+	}
+	// The selection includes the character under the cursor
+	last, _ := e.prevPos(to)
+	e.selection = selection{kind: visualChar, anchor: from}
+	e.setCursor(last)
+	e.updateDisplay()
+}
+
+// visualOperate deletes, changes or yanks the selection and leaves Visual
+// Mode.
+func (e *TextEditor) visualOperate(name string) {
+	op := &operator{name: name, reg: e.regName}
+	e.regName = 0
+	from, to := e.selectionBounds()
+	e.mode = ViewMode
+
+	switch e.selection.kind {
+	case visualChar:
+		e.operate(op, from, to, inclusive)
+	case visualLine:
+		e.operate(op, from, to, linewise)
+	case visualBlock:
+		e.operateBlock(op, from.line, to.line)
+	}
+}
+
+// operateBlock deletes, changes or yanks a block selection. Changing a block
+// deletes it and types at its top left corner.
+func (e *TextEditor) operateBlock(op *operator, first, last int) {
+	left, _ := e.blockColumns()
+	var parts []string
+	for n := first; n <= last; n++ {
+		start, end := e.selectionIn(n)
+		parts = append(parts, e.buf.text.Line(n)[start:end])
+	}
+	e.storeRegister(op, register{text: strings.Join(parts, "\n"), block: true})
+
+	top := cursorPos{first, colForDisplay(e.buf.text.Line(first), left)}
+	if op.name == "yank" {
+		e.setCursor(top)
+		e.updateDisplay()
+		return
+	}
+
+	e.buf.history.Close()
+	for n := last; n >= first; n-- {
+		start, end := e.selectionIn(n)
+		e.deleteText(e.buf.text.LineStart(n)+start, end-start)
+	}
+	e.setCursor(top)
+	e.editDone()
+	if op.name == "change" {
+		e.enterEditMode()
+		return
+	}
+	e.buf.history.Close()
+}
+
+// targetLines returns the lines a command works on: the selected lines in
+// Visual Mode, or its range.
+func (e *TextEditor) targetLines(c *exCommand) (int, int) {
+	if e.mode == VisualMode {
+		return e.selectedLines()
+	}
+	return c.start, c.end
+}
+
+// changeCase changes the case of the selection, or of the lines of the
+// command's range.
+func (e *TextEditor) changeCase(c *exCommand, change func(string) string) {
+	if e.showWelcome {
+		return
+	}
+	visual := e.mode == VisualMode
+	first, last := e.targetLines(c)
+	from := cursorPos{first, 0}
+	if visual {
+		from, _ = e.selectionBounds()
+		if e.selection.kind == visualBlock {
+			left, _ := e.blockColumns()
+			from.col = colForDisplay(e.buf.text.Line(first), left)
+		}
+	}
+
+	e.buf.history.Close()
+	for n := first; n <= last; n++ {
+		line := e.buf.text.Line(n)
+		start, end := 0, len(line)
+		if visual {
+			start, end = e.selectionIn(n)
+		}
+		e.replaceText(e.buf.text.LineStart(n)+start, end-start, change(line[start:end]))
+	}
+	if visual {
+		e.mode = ViewMode
+	}
+	e.setCursor(from)
+	e.editDone()
+	e.buf.history.Close()
+}
+
+func toggleCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, s)
+}
+
+// shiftBy indents the selected lines or the command's range, count times in
+// Visual Mode.
+func (e *TextEditor) shiftBy(c *exCommand, right bool) {
+	times := 1
+	if e.mode == VisualMode {
+		times = c.times()
+	}
+	first, last := e.targetLines(c)
+	e.shiftLines(first, last, times, right)
+}
+
+// shiftLines indents lines first to last by times the tab width, or takes as
+// much indentation away. Empty lines stay empty.
+func (e *TextEditor) shiftLines(first, last, times int, right bool) {
+	if e.showWelcome {
+		return
+	}
+	width := e.tabWidth(e.buf) * times
+
+	e.buf.history.Close()
+	for n := first; n <= last; n++ {
+		line := e.buf.text.Line(n)
+		start := e.buf.text.LineStart(n)
+		if right {
+			if line != "" {
+				e.insertText(start, strings.Repeat(" ", width))
+			}
+			continue
+		}
+		// A tab counts as a whole indentation step
+		cut, cells := 0, 0
+		for cut < len(line) && cells < width && (line[cut] == ' ' || line[cut] == '\t') {
+			if line[cut] == '\t' {
+				cells += e.tabWidth(e.buf)
+			} else {
+				cells++
+			}
+			cut++
+		}
+		e.deleteText(start, cut)
+	}
+	e.mode = ViewMode
+	e.setCursor(cursorPos{first, e.firstNonBlank(first)})
+	e.editDone()
+	e.buf.history.Close()
+
+	if lines := last - first + 1; lines > 2 {
+		e.updateStatusBar(fmt.Sprintf("%d lines indented", lines))
+	}
+}
+
+// filterLines replaces lines first to last with the output of a shell
+// command given them as input.
+func (e *TextEditor) filterLines(first, last int, command string) error {
+	if e.showWelcome {
+		return nil
+	}
+	start := e.buf.text.LineStart(first)
+	end := e.buf.text.Len()
+	if last < e.lastLine() {
+		end = e.buf.text.LineStart(last + 1)
+	}
+	input := e.buf.text.Slice(start, end)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); msg != "" {
+			return fmt.Errorf("%s: %s", command, msg)
+		}
+		return fmt.Errorf("%s: %v", command, err)
+	}
+
+	output := stdout.String()
+	if !strings.HasSuffix(input, "\n") {
+		output = strings.TrimSuffix(output, "\n")
+	}
+	e.buf.history.Close()
+	e.replaceText(start, end-start, output)
+	e.mode = ViewMode
+	e.setCursor(cursorPos{first, 0})
+	e.editDone()
+	e.buf.history.Close()
+	e.updateStatusBar(fmt.Sprintf("%d lines filtered", last-first+1))
+	return nil
+}
+
+// startVisualCommandLine opens the command line for the selected lines, with
+// text after their range.
+func (e *TextEditor) startVisualCommandLine(text string) {
+	first, last := e.selectedLines()
+	e.enterViewMode()
+	e.startCommandLine()
+	e.prompt.SetText(fmt.Sprintf("%d,%d%s", first+1, last+1, text))
+}
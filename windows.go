@@ -0,0 +1,343 @@
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Windows
+//
+// The text area is split into windows, each showing a buffer in its own
+// viewport with its own cursor. Several windows can show the same buffer, and
+// an edit in one of them shows up in the others right away. The editor keeps
+// the cursor of the active window in lineNum, colNum and topLine; the other
+// windows keep theirs as a position that follows edits to the buffer.
+
+// position is a cursor and scroll position stored as byte offsets, so it can
+// be moved along when text is inserted or deleted before it.
+type position struct {
+	cursor int
+	top    int
+}
+
+// adjust moves the position past a change of the buffer at offset.
+func (p *position) adjust(offset, removed, inserted int) {
+	p.cursor = adjustOffset(p.cursor, offset, removed, inserted)
+	p.top = adjustOffset(p.top, offset, removed, inserted)
+}
+
+func adjustOffset(at, offset, removed, inserted int) int {
+	switch {
+	case at >= offset+removed:
+		return at + inserted - removed
+	case at > offset:
+		// Inside the removed text
+		return offset
+	}
+	return at
+}
+
+type Window struct {
+	buf    *Buffer
+	pos    position
+	view   *Viewport
+	label  *tview.TextView
+	node   *layoutNode
+	render func(width, height int) string
+}
+
+// layoutNode is a node of the window layout: either a single window, or a
+// split into children shown side by side (vertical) or stacked.
+type layoutNode struct {
+	window   *Window
+	vertical bool
+	children []*layoutNode
+	parent   *layoutNode
+	weight   int
+}
+
+// Every window starts with this share of its split, resizing moves it by one
+const windowWeight = 10
+
+func (e *TextEditor) newWindow(b *Buffer) *Window {
+	w := &Window{
+		buf:  b,
+		view: NewViewport(),
+		label: tview.NewTextView().
+			SetDynamicColors(true),
+	}
+	w.render = func(width, height int) string {
+		return e.renderWindow(w, width, height)
+	}
+	w.view.SetRenderer(w.render)
+	w.view.SetInputCapture(e.handleKey)
+	e.windows = append(e.windows, w)
+	return w
+}
+
+// position returns the cursor and scroll position of the active window.
+func (e *TextEditor) position() position {
+	return position{cursor: e.cursorOffset(), top: e.buf.text.LineStart(e.topLine)}
+}
+
+// setPosition moves the cursor of the active window to p.
+func (e *TextEditor) setPosition(p position) {
+	text := e.buf.text
+	offset := min(max(p.cursor, 0), text.Len())
+	line := text.LineOf(offset)
+	e.lineNum = line
+	e.colNum = runeLen(text.Slice(text.LineStart(line), offset))
+	e.topLine = text.LineOf(min(max(p.top, 0), text.Len()))
+}
+
+// splitWindow opens a second window on the current buffer, next to the
+// active one if vertical and below it otherwise, and moves to it.
+func (e *TextEditor) splitWindow(vertical bool) {
+	if e.showWelcome {
+		return
+	}
+
+	node := e.win.node
+	w := e.newWindow(e.buf)
+	w.pos = e.position()
+	leaf := &layoutNode{window: w, weight: node.weight}
+	w.node = leaf
+
+	if parent := node.parent; parent != nil && parent.vertical == vertical {
+		index := parent.indexOf(node)
+		leaf.parent = parent
+		parent.children = append(parent.children[:index+1], append([]*layoutNode{leaf}, parent.children[index+1:]...)...)
+	} else {
+		// Turn the window's node into a split holding the old and new window
+		old := &layoutNode{window: e.win, parent: node, weight: windowWeight}
+		e.win.node = old
+		leaf.parent = node
+		leaf.weight = windowWeight
+		node.window = nil
+		node.vertical = vertical
+		node.children = []*layoutNode{old, leaf}
+	}
+
+	e.app.SetRoot(e.getMainLayout(), true)
+	e.focusWindow(w)
+}
+
+// closeWindow closes the active window. The buffer stays open.
+func (e *TextEditor) closeWindow() {
+	if len(e.windows) == 1 {
+		e.updateStatusBar("Can't close the last window")
+		return
+	}
+
+	node := e.win.node
+	parent := node.parent
+	index := parent.indexOf(node)
+	parent.children = append(parent.children[:index], parent.children[index+1:]...)
+	if len(parent.children) == 1 {
+		// A split with one window left is just that window
+		only := parent.children[0]
+		parent.window = only.window
+		parent.vertical = only.vertical
+		parent.children = only.children
+		for _, child := range parent.children {
+			child.parent = parent
+		}
+		if parent.window != nil {
+			parent.window.node = parent
+		}
+	}
+	e.windows = append(e.windows[:e.windowIndex(e.win)], e.windows[e.windowIndex(e.win)+1:]...)
+
+	next := parent.children
+	if parent.window == nil {
+		parent = next[min(index, len(next)-1)]
+	}
+	e.buf.history.Close()
+	e.win = nil
+	e.app.SetRoot(e.getMainLayout(), true)
+	e.focusWindow(parent.firstWindow())
+}
+
+// onlyWindow closes every window except the active one.
+func (e *TextEditor) onlyWindow() {
+	e.windows = []*Window{e.win}
+	e.layout = &layoutNode{window: e.win, weight: windowWeight}
+	e.win.node = e.layout
+	e.app.SetRoot(e.getMainLayout(), true)
+	e.updateDisplay()
+}
+
+// focusWindow makes w the active window.
+func (e *TextEditor) focusWindow(w *Window) {
+	if e.win != nil {
+		e.buf.history.Close()
+		e.win.pos = e.position()
+	}
+	e.win = w
+	e.buf = w.buf
+	e.setPosition(w.pos)
+	e.app.SetFocus(w.view)
+	e.updateDisplay()
+}
+
+// cycleWindow moves to the next window, left to right and top to bottom.
+func (e *TextEditor) cycleWindow() {
+	windows := e.layout.windows(nil)
+	for i, w := range windows {
+		if w == e.win {
+			e.focusWindow(windows[(i+1)%len(windows)])
+			return
+		}
+	}
+}
+
+// moveFocus moves to the nearest window in the direction dx, dy from the
+// cursor, using where the windows were last drawn.
+func (e *TextEditor) moveFocus(dx, dy int) {
+	x, y, width, height := e.win.view.GetRect()
+	cursorY := y + e.lineNum - e.topLine
+	cursorX := x
+
+	var best *Window
+	bestDistance := 0
+	for _, w := range e.windows {
+		if w == e.win {
+			continue
+		}
+		wx, wy, wWidth, wHeight := w.view.GetRect()
+
+		var distance int
+		switch {
+		case dx > 0 && wx >= x+width && cursorY >= wy && cursorY < wy+wHeight+1:
+			distance = wx - (x + width)
+		case dx < 0 && wx+wWidth <= x && cursorY >= wy && cursorY < wy+wHeight+1:
+			distance = x - (wx + wWidth)
+		case dy > 0 && wy >= y+height && cursorX >= wx && cursorX < wx+wWidth+1:
+			distance = wy - (y + height)
+		case dy < 0 && wy+wHeight <= y && cursorX >= wx && cursorX < wx+wWidth+1:
+			distance = y - (wy + wHeight)
+		default:
+			continue
+		}
+		if best == nil || distance < bestDistance {
+			best, bestDistance = w, distance
+		}
+	}
+
+	if best != nil {
+		e.focusWindow(best)
+	}
+}
+
+// resizeWindow grows the active window by delta steps, across if vertical
+// and down otherwise, taking the space from the windows next to it.
+func (e *TextEditor) resizeWindow(vertical bool, delta int) {
+	node := e.win.node
+	for node.parent != nil && node.parent.vertical != vertical {
+		node = node.parent
+	}
+	if node.parent == nil {
+		return
+	}
+	node.weight = max(node.weight+delta, 1)
+	e.app.SetRoot(e.getMainLayout(), true)
+}
+
+func (e *TextEditor) windowIndex(w *Window) int {
+	for i, candidate := range e.windows {
+		if candidate == w {
+			return i
+		}
+	}
+	return -1
+}
+
+func (n *layoutNode) indexOf(child *layoutNode) int {
+	for i, candidate := range n.children {
+		if candidate == child {
+			return i
+		}
+	}
+	return -1
+}
+
+// windows appends the windows below n to list in layout order.
+func (n *layoutNode) windows(list []*Window) []*Window {
+	if n.window != nil {
+		return append(list, n.window)
+	}
+	for _, child := range n.children {
+		list = child.windows(list)
+	}
+	return list
+}
+
+func (n *layoutNode) firstWindow() *Window {
+	for n.window == nil {
+		n = n.children[0]
+	}
+	return n.window
+}
+
+// layoutPrimitive builds the flex boxes for the layout below n.
+func (e *TextEditor) layoutPrimitive(n *layoutNode) tview.Primitive {
+	if n.window != nil {
+		if len(e.windows) == 1 {
+			return n.window.view
+		}
+		return tview.NewFlex().
+			SetDirection(tview.FlexRow).
+			AddItem(n.window.view, 0, 1, true).
+			AddItem(n.window.label, 1, 0, false)
+	}
+
+	flex := tview.NewFlex()
+	if !n.vertical {
+		flex.SetDirection(tview.FlexRow)
+	}
+	for i, child := range n.children {
+		if n.vertical && i > 0 {
+			flex.AddItem(newSeparator(), 1, 0, false)
+		}
+		flex.AddItem(e.layoutPrimitive(child), 0, child.weight, child.contains(e.win))
+	}
+	return flex
+}
+
+func (n *layoutNode) contains(w *Window) bool {
+	if n.window != nil {
+		return n.window == w
+	}
+	for _, child := range n.children {
+		if child.contains(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// newSeparator returns the line drawn between windows side by side.
+func newSeparator() tview.Primitive {
+	return tview.NewBox().SetDrawFunc(func(screen tcell.Screen, x, y, width, height int) (int, int, int, int) {
+		for row := y; row < y+height; row++ {
+			screen.SetContent(x, row, tview.Borders.Vertical, nil, tcell.StyleDefault.Foreground(tcell.ColorGray))
+		}
+		return x, y, width, height
+	})
+}
+
+// updateWindowLabels refreshes the name shown under each window.
+func (e *TextEditor) updateWindowLabels() {
+	for _, w := range e.windows {
+		label := " " + tview.Escape(w.buf.name())
+		if w.buf.modified {
+			label += " [+]"
+		}
+		w.label.SetText(label)
+		if w == e.win {
+			w.label.SetTextStyle(tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorAqua))
+		} else {
+			w.label.SetTextStyle(tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorGray))
+		}
+	}
+}